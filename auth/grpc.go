@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmnhs/common/logger"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor is JWTAuth for grpc, it verifies the access token of the authorization(Bearer) or token
+// metadata with the default manager and puts the claims on the context. The methods in skip, e.g.
+// "/grpc.health.v1.Health/Check", are not authenticated.
+func UnaryServerInterceptor(skip ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if skipMethod(info.FullMethod, skip) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticateGrpc(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for the streams
+func StreamServerInterceptor(skip ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if skipMethod(info.FullMethod, skip) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticateGrpc(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &claimsStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// TokenFromMetadata returns the bearer token of the authorization metadata, or the token metadata
+func TokenFromMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		if authorization := values[0]; len(authorization) > len(bearer) && strings.EqualFold(authorization[:len(bearer)], bearer) {
+			return strings.TrimSpace(authorization[len(bearer):])
+		}
+	}
+	if values := md.Get(strings.ToLower(headerToken)); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func authenticateGrpc(ctx context.Context) (context.Context, error) {
+	m := GetManager()
+	if m == nil {
+		return nil, status.Error(codes.Internal, "jwt is not initialized")
+	}
+	token := TokenFromMetadata(ctx)
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "token is missing")
+	}
+	claims, err := m.Verify(ctx, token)
+	if err != nil {
+		if IsTokenError(err) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		logger.WithContext(ctx).Error(fmt.Sprintf("auth:verify token error:%s", err.Error()))
+		return nil, status.Error(codes.Internal, errVerifyMessage)
+	}
+	return NewContext(ctx, claims), nil
+}
+
+func skipMethod(method string, skip []string) bool {
+	for _, s := range skip {
+		if s == method {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *claimsStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,363 @@
+package auth
+
+// JWT issuing and verification with key rotation, leeway, refresh tokens and revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tmnhs/common/utils"
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+
+	headerKid = "kid"
+)
+
+var (
+	ErrTokenInvalid  = errors.New("token is invalid")
+	ErrTokenExpired  = errors.New("token is expired")
+	ErrTokenRevoked  = errors.New("token is revoked")
+	ErrTokenType     = errors.New("unexpected token type")
+	ErrUnknownKey    = errors.New("unknown signing key")
+	ErrNoSigningKey  = errors.New("no signing key")
+	ErrNotRefreshing = errors.New("refresh tokens are disabled")
+)
+
+// Claims are the claims of the issued tokens, put on the gin and request contexts by the middleware
+type Claims struct {
+	UserID    string                 `json:"uid,omitempty"`
+	Roles     []string               `json:"roles,omitempty"`
+	TokenType string                 `json:"token_type,omitempty"`
+	Extra     map[string]interface{} `json:"ext,omitempty"`
+	jwt.StandardClaims
+}
+
+// TokenPair is the result of a login or of a refresh
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+// Manager issues and verifies tokens, it is safe for concurrent use
+type Manager struct {
+	mu        sync.RWMutex
+	keys      map[string]*Key
+	activeKid string
+
+	issuer           string
+	audience         string
+	expiresIn        time.Duration
+	refreshExpiresIn time.Duration
+	leeway           time.Duration
+	revoker          Revoker
+	now              func() time.Time
+}
+
+type Option func(m *Manager)
+
+// WithKey adds a signing or verification key, the first key signs the tokens unless WithActiveKey is used
+func WithKey(key *Key) Option {
+	return func(m *Manager) {
+		m.keys[key.ID] = key
+		if m.activeKid == "" {
+			m.activeKid = key.ID
+		}
+	}
+}
+
+// WithActiveKey selects the key signing new tokens
+func WithActiveKey(kid string) Option {
+	return func(m *Manager) {
+		m.activeKid = kid
+	}
+}
+
+// WithIssuer sets and checks the iss claim
+func WithIssuer(issuer string) Option {
+	return func(m *Manager) {
+		m.issuer = issuer
+	}
+}
+
+// WithAudience sets and checks the aud claim
+func WithAudience(audience string) Option {
+	return func(m *Manager) {
+		m.audience = audience
+	}
+}
+
+// WithExpiry sets the lifetime of the access and refresh tokens, refresh tokens are not issued when refresh is 0
+func WithExpiry(access, refresh time.Duration) Option {
+	return func(m *Manager) {
+		m.expiresIn, m.refreshExpiresIn = access, refresh
+	}
+}
+
+// WithLeeway sets the clock skew tolerated when checking exp, nbf and iat
+func WithLeeway(leeway time.Duration) Option {
+	return func(m *Manager) {
+		m.leeway = leeway
+	}
+}
+
+// WithRevoker rejects the revoked tokens, see NewRedisRevoker
+func WithRevoker(r Revoker) Option {
+	return func(m *Manager) {
+		m.revoker = r
+	}
+}
+
+func NewManager(opts ...Option) (*Manager, error) {
+	m := &Manager{
+		keys:      make(map[string]*Key),
+		expiresIn: 2 * time.Hour,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if len(m.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	if _, ok := m.keys[m.activeKid]; !ok {
+		return nil, fmt.Errorf("active key[%s]: %w", m.activeKid, ErrUnknownKey)
+	}
+	return m, nil
+}
+
+// AddKey adds a key at runtime, e.g. the next key of a rotation
+func (m *Manager) AddKey(key *Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.ID] = key
+}
+
+// RemoveKey removes a retired key, the tokens it signed are rejected afterwards
+func (m *Manager) RemoveKey(kid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if kid == m.activeKid {
+		return fmt.Errorf("key[%s] is active", kid)
+	}
+	delete(m.keys, kid)
+	return nil
+}
+
+// SetActiveKey switches the key signing new tokens, the previous keys still verify the tokens they signed
+func (m *Manager) SetActiveKey(kid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[kid]
+	if !ok {
+		return fmt.Errorf("key[%s]: %w", kid, ErrUnknownKey)
+	}
+	if key.SignKey == nil {
+		return fmt.Errorf("key[%s] has no private key", kid)
+	}
+	m.activeKid = kid
+	return nil
+}
+
+// Issue signs an access token, the registered claims are filled in when empty
+func (m *Manager) Issue(claims Claims) (string, time.Time, error) {
+	claims.TokenType = TokenTypeAccess
+	return m.sign(claims, m.expiresIn)
+}
+
+// IssuePair signs an access token and, when enabled, a refresh token
+func (m *Manager) IssuePair(claims Claims) (*TokenPair, error) {
+	access, expiresAt, err := m.Issue(claims)
+	if err != nil {
+		return nil, err
+	}
+	pair := &TokenPair{AccessToken: access, ExpiresAt: expiresAt}
+	if m.refreshExpiresIn <= 0 {
+		return pair, nil
+	}
+	claims.TokenType = TokenTypeRefresh
+	pair.RefreshToken, pair.RefreshExpiresAt, err = m.sign(claims, m.refreshExpiresIn)
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Verify checks the signature, the registered claims and the revocation of an access token
+func (m *Manager) Verify(ctx context.Context, token string) (*Claims, error) {
+	return m.verify(ctx, token, TokenTypeAccess)
+}
+
+// Refresh exchanges a refresh token for a new pair, the refresh token is revoked when a Revoker is set
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if m.refreshExpiresIn <= 0 {
+		return nil, ErrNotRefreshing
+	}
+	claims, err := m.verify(ctx, refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Revoke(ctx, claims); err != nil {
+		return nil, err
+	}
+	return m.IssuePair(Claims{UserID: claims.UserID, Roles: claims.Roles, Extra: claims.Extra})
+}
+
+// Revoke rejects the token until it expires, it does nothing without a Revoker
+func (m *Manager) Revoke(ctx context.Context, claims *Claims) error {
+	if m.revoker == nil || claims.Id == "" {
+		return nil
+	}
+	return m.revoker.Revoke(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0).Add(m.leeway))
+}
+
+// RevokeToken revokes an access or a refresh token, e.g. on logout
+func (m *Manager) RevokeToken(ctx context.Context, token string) error {
+	claims, err := m.verify(ctx, token, "")
+	if err != nil {
+		return err
+	}
+	return m.Revoke(ctx, claims)
+}
+
+func (m *Manager) sign(claims Claims, expiresIn time.Duration) (string, time.Time, error) {
+	m.mu.RLock()
+	key := m.keys[m.activeKid]
+	m.mu.RUnlock()
+	if key == nil || key.SignKey == nil {
+		return "", time.Time{}, ErrNoSigningKey
+	}
+
+	now := m.now()
+	expiresAt := now.Add(expiresIn)
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = expiresAt.Unix()
+	if claims.Id == "" || claims.TokenType == TokenTypeRefresh {
+		claims.Id = newTokenID()
+	}
+	if claims.Issuer == "" {
+		claims.Issuer = m.issuer
+	}
+	if claims.Audience == "" {
+		claims.Audience = m.audience
+	}
+
+	token := jwt.NewWithClaims(key.Method, &claims)
+	token.Header[headerKid] = key.ID
+	signed, err := token.SignedString(key.SignKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+func (m *Manager) verify(ctx context.Context, token string, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	//the registered claims are checked below with the leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, claims, m.keyFunc); err != nil {
+		var ve *jwt.ValidationError
+		if errors.As(err, &ve) && errors.Is(ve.Inner, ErrUnknownKey) {
+			return nil, ErrUnknownKey
+		}
+		return nil, ErrTokenInvalid
+	}
+	if err := m.validate(claims); err != nil {
+		return nil, err
+	}
+	if tokenType != "" && claims.TokenType != tokenType {
+		return nil, ErrTokenType
+	}
+	if m.revoker != nil && claims.Id != "" {
+		revoked, err := m.revoker.IsRevoked(ctx, claims.Id)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// keyFunc selects the key by kid and refuses an algorithm other than the one of the key
+func (m *Manager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header[headerKid].(string)
+	m.mu.RLock()
+	if kid == "" {
+		kid = m.activeKid
+	}
+	key := m.keys[kid]
+	m.mu.RUnlock()
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.VerifyKey, nil
+}
+
+func (m *Manager) validate(claims *Claims) error {
+	now := m.now()
+	if claims.ExpiresAt == 0 {
+		//a token without exp would never expire
+		return ErrTokenInvalid
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(m.leeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(m.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrTokenInvalid
+	}
+	if claims.IssuedAt != 0 && now.Add(m.leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return ErrTokenInvalid
+	}
+	if m.issuer != "" && claims.Issuer != m.issuer {
+		return ErrTokenInvalid
+	}
+	if m.audience != "" && claims.Audience != m.audience {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// IsTokenError reports whether err of Verify or Refresh is caused by the token, the other errors, e.g. of the
+// revoker, are internal ones
+func IsTokenError(err error) bool {
+	for _, target := range []error{ErrTokenInvalid, ErrTokenExpired, ErrTokenRevoked, ErrTokenType, ErrUnknownKey} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the claims contain one of the roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, r := range c.Roles {
+			if strings.EqualFold(r, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newTokenID() string {
+	id, err := utils.UUID()
+	if err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return id
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common/logger"
+)
+
+type memoryRevoker struct {
+	sync.Mutex
+	ids map[string]time.Time
+}
+
+func (r *memoryRevoker) Revoke(ctx context.Context, id string, until time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	r.ids[id] = until
+	return nil
+}
+
+func (r *memoryRevoker) IsRevoked(ctx context.Context, id string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.ids[id]
+	return ok, nil
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	oldKey, err := NewHMACKey("k1", "HS256", []byte("secret-1"))
+	assert.Nil(t, err)
+	m, err := NewManager(WithKey(oldKey), WithIssuer("common"), WithExpiry(time.Hour, 24*time.Hour),
+		WithLeeway(time.Minute), WithRevoker(&memoryRevoker{ids: make(map[string]time.Time)}))
+	assert.Nil(t, err)
+
+	pair, err := m.IssuePair(Claims{UserID: "42", Roles: []string{"admin"}})
+	assert.Nil(t, err)
+	claims, err := m.Verify(ctx, pair.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "42", claims.UserID)
+	assert.True(t, claims.HasRole("admin"))
+	_, err = m.Verify(ctx, pair.RefreshToken)
+	assert.Equal(t, ErrTokenType, err)
+
+	//expiry with leeway
+	m.now = func() time.Time { return time.Now().Add(time.Hour + 30*time.Second) }
+	_, err = m.Verify(ctx, pair.AccessToken)
+	assert.Nil(t, err)
+	m.now = func() time.Time { return time.Now().Add(time.Hour + 2*time.Minute) }
+	_, err = m.Verify(ctx, pair.AccessToken)
+	assert.Equal(t, ErrTokenExpired, err)
+	m.now = time.Now
+
+	//rotation: tokens of the previous key stay valid
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	newKey, err := NewRSAKey("k2", "RS256", privatePEM, nil)
+	assert.Nil(t, err)
+	m.AddKey(newKey)
+	assert.Nil(t, m.SetActiveKey("k2"))
+	token, _, err := m.Issue(Claims{UserID: "43"})
+	assert.Nil(t, err)
+	_, err = m.Verify(ctx, token)
+	assert.Nil(t, err)
+	_, err = m.Verify(ctx, pair.AccessToken)
+	assert.Nil(t, err)
+	assert.Nil(t, m.RemoveKey("k1"))
+	_, err = m.Verify(ctx, pair.AccessToken)
+	assert.Equal(t, ErrUnknownKey, err)
+
+	//refresh rotates the refresh token
+	m.AddKey(oldKey)
+	refreshed, err := m.Refresh(ctx, pair.RefreshToken)
+	assert.Nil(t, err)
+	claims, err = m.Verify(ctx, refreshed.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "42", claims.UserID)
+	_, err = m.Refresh(ctx, pair.RefreshToken)
+	assert.Equal(t, ErrTokenRevoked, err)
+
+	//a signed token without exp is rejected
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "42", TokenType: TokenTypeAccess}).SignedString([]byte("secret-1"))
+	assert.Nil(t, err)
+	_, err = m.Verify(ctx, token)
+	assert.Equal(t, ErrTokenInvalid, err)
+}
+
+type failingRevoker struct{}
+
+func (failingRevoker) Revoke(ctx context.Context, id string, until time.Time) error {
+	return errors.New("redis: connection refused")
+}
+
+func (failingRevoker) IsRevoked(ctx context.Context, id string) (bool, error) {
+	return false, errors.New("redis: connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+	key, _ := NewHMACKey("k1", "HS256", []byte("secret"))
+	m, err := NewManager(WithKey(key))
+	assert.Nil(t, err)
+	token, _, _ := m.Issue(Claims{UserID: "42", Roles: []string{"user"}})
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(m.Middleware())
+	engine.GET("/me", func(c *gin.Context) {
+		claims, _ := ClaimsFromContext(c.Request.Context())
+		c.String(http.StatusOK, claims.UserID+" "+c.GetString(UserIDKey))
+	})
+	engine.GET("/admin", RequireRoles("admin"), func(c *gin.Context) {})
+	serve := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/me", "Bearer "+token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "42 42", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, serve("/me", "Bearer x").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("/me", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve("/admin", "Bearer "+token).Code)
+
+	//the errors of the revoker are not token errors
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	WithRevoker(failingRevoker{})(m)
+	w = serve("/me", "Bearer "+token)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "redis")
+}
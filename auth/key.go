@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Key is a signing key identified by the kid header, SignKey is nil for a verification only key
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// NewHMACKey returns a HS256/HS384/HS512 key
+func NewHMACKey(kid, algorithm string, secret []byte) (*Key, error) {
+	method, ok := jwt.GetSigningMethod(algorithm).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("key[%s]: %s is not an HS algorithm", kid, algorithm)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("key[%s]: empty secret", kid)
+	}
+	return &Key{ID: kid, Method: method, SignKey: secret, VerifyKey: secret}, nil
+}
+
+// NewRSAKey returns a RS256/RS384/RS512 key from PEM blocks,
+// the public key is derived from the private key when empty, without private key the key only verifies tokens
+func NewRSAKey(kid, algorithm string, privatePEM, publicPEM []byte) (*Key, error) {
+	method, ok := jwt.GetSigningMethod(algorithm).(*jwt.SigningMethodRSA)
+	if !ok {
+		return nil, fmt.Errorf("key[%s]: %s is not an RS algorithm", kid, algorithm)
+	}
+	key := &Key{ID: kid, Method: method}
+	if len(privatePEM) > 0 {
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+		if err != nil {
+			return nil, fmt.Errorf("key[%s]: %w", kid, err)
+		}
+		key.SignKey, key.VerifyKey = private, &private.PublicKey
+	}
+	if len(publicPEM) > 0 {
+		public, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+		if err != nil {
+			return nil, fmt.Errorf("key[%s]: %w", kid, err)
+		}
+		key.VerifyKey = public
+	}
+	if key.VerifyKey == nil {
+		return nil, fmt.Errorf("key[%s]: a private or a public key is required", kid)
+	}
+	return key, nil
+}
+
+// NewKey returns a HS or RS key depending on the algorithm
+func NewKey(kid, algorithm, secret, privatePEM, publicPEM string) (*Key, error) {
+	if strings.HasPrefix(algorithm, "HS") {
+		return NewHMACKey(kid, algorithm, []byte(secret))
+	}
+	return NewRSAKey(kid, algorithm, []byte(privatePEM), []byte(publicPEM))
+}
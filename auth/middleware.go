@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+)
+
+const (
+	//ClaimsKey is the key of the *Claims in the gin context
+	ClaimsKey = "jwt-claims"
+	//UserIDKey is the key of the user id in the gin context, the default rate limit user-key
+	UserIDKey = "user-id"
+
+	headerToken = "Token"
+	bearer      = "Bearer "
+
+	//message of the internal errors of Verify, e.g. redis errors of the revoker, which are logged instead
+	errVerifyMessage = "failed to verify the token"
+)
+
+type claimsKey struct{}
+
+//默认的Manager，由Init根据配置创建
+var _defaultManager atomic.Value
+
+// Init builds the default manager from the jwt config
+func Init(c common.Jwt) (*Manager, error) {
+	m, err := NewManagerFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	_defaultManager.Store(m)
+	return m, nil
+}
+
+// GetManager returns the default manager, nil before Init
+func GetManager() *Manager {
+	m, _ := _defaultManager.Load().(*Manager)
+	return m
+}
+
+// NewManagerFromConfig builds a manager from the jwt config, revocation uses the redis of redisclient
+func NewManagerFromConfig(c common.Jwt) (*Manager, error) {
+	opts := []Option{
+		WithIssuer(c.Issuer),
+		WithAudience(c.Audience),
+		WithExpiry(c.ExpiresIn, c.RefreshExpiresIn),
+		WithLeeway(c.Leeway),
+	}
+	for _, k := range c.Keys {
+		key, err := NewKey(k.Kid, k.Algorithm, k.Secret, k.PrivateKey, k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKey(key))
+	}
+	if c.ActiveKid != "" {
+		opts = append(opts, WithActiveKey(c.ActiveKid))
+	}
+	if c.Revocation {
+		opts = append(opts, WithRevoker(NewRedisRevoker()))
+	}
+	return NewManager(opts...)
+}
+
+// JWTAuth verifies the access token with the default manager, see Manager.Middleware
+func JWTAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := GetManager()
+		if m == nil {
+			common.FailWithStatus(http.StatusInternalServerError, common.ERROR, "jwt is not initialized", c)
+			return
+		}
+		m.authenticate(c)
+	}
+}
+
+// Middleware verifies the access token of the Authorization(Bearer) or Token header and puts the claims
+// on the gin and request contexts, see ClaimsFromContext. Invalid tokens get 401.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return m.authenticate
+}
+
+func (m *Manager) authenticate(c *gin.Context) {
+	token := TokenFromRequest(c.Request)
+	if token == "" {
+		common.FailWithStatus(http.StatusUnauthorized, common.ErrorTokenInvalid, "token is missing", c)
+		return
+	}
+	claims, err := m.Verify(c.Request.Context(), token)
+	if err != nil && !IsTokenError(err) {
+		logger.WithContext(c).Error(fmt.Sprintf("auth:verify token error:%s", err.Error()))
+		common.FailWithStatus(http.StatusInternalServerError, common.ERROR, errVerifyMessage, c)
+		return
+	}
+	if err != nil {
+		code := common.ErrorTokenInvalid
+		if errors.Is(err, ErrTokenExpired) {
+			code = common.ErrorTokenExpired
+		}
+		common.FailWithStatus(http.StatusUnauthorized, code, err.Error(), c)
+		return
+	}
+	c.Set(ClaimsKey, claims)
+	c.Set(UserIDKey, claims.UserID)
+	c.Request = c.Request.WithContext(NewContext(c.Request.Context(), claims))
+	c.Next()
+}
+
+// RequireRoles rejects with 403 the requests whose claims have none of the roles, use it after the middleware
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := ClaimsFromContext(c)
+		if !ok || !claims.HasRole(roles...) {
+			common.FailWithStatus(http.StatusForbidden, common.ErrorPermissionDenied, "permission denied", c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// TokenFromRequest returns the bearer token of the Authorization header, or the Token header
+func TokenFromRequest(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); len(authorization) > len(bearer) && strings.EqualFold(authorization[:len(bearer)], bearer) {
+		return strings.TrimSpace(authorization[len(bearer):])
+	}
+	return r.Header.Get(headerToken)
+}
+
+// NewContext returns a copy of ctx carrying the claims
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of a request context or of a gin context
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	if claims, ok := ctx.Value(claimsKey{}).(*Claims); ok {
+		return claims, true
+	}
+	claims, ok := ctx.Value(ClaimsKey).(*Claims)
+	return claims, ok
+}
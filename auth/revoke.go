@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tmnhs/common/redisclient"
+)
+
+const revokedKeyPrefix = "jwt:revoked:"
+
+// Revoker keeps the ids(jti) of the revoked tokens until they expire
+type Revoker interface {
+	Revoke(ctx context.Context, id string, until time.Time) error
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// RedisRevoker keeps the revoked tokens in the redis of redisclient, shared by every instance
+type RedisRevoker struct{}
+
+func NewRedisRevoker() *RedisRevoker {
+	return &RedisRevoker{}
+}
+
+func (RedisRevoker) Revoke(ctx context.Context, id string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		//already expired
+		return nil
+	}
+	client := redisclient.GetRedis()
+	if client == nil {
+		return fmt.Errorf("redis is not initialized")
+	}
+	return client.Set(ctx, revokedKeyPrefix+id, 1, ttl).Err()
+}
+
+func (RedisRevoker) IsRevoked(ctx context.Context, id string) (bool, error) {
+	client := redisclient.GetRedis()
+	if client == nil {
+		return false, fmt.Errorf("redis is not initialized")
+	}
+	n, err := client.Exists(ctx, revokedKeyPrefix+id).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
+	zhtranslations "github.com/go-playground/validator/v10/translations/zh"
+)
+
+// ParamError is the error of one request parameter, the Data of the response of the Bind helpers lists them
+type ParamError struct {
+	Field   string `json:"field"`   // json name of the field, or its form or uri name when it has no json tag, e.g. items[0].name
+	Tag     string `json:"tag"`     // failed rule of the binding tag, e.g. required, or "type" when the value cannot be decoded
+	Message string `json:"message"` // translated message
+}
+
+const (
+	LangEn = "en"
+	LangZh = "zh"
+)
+
+var (
+	_bindTranslator     *ut.UniversalTranslator
+	_bindTranslatorOnce sync.Once
+
+	bindMessages = map[string]map[string]string{
+		LangEn: {
+			"type":    "%s must be of type %s",
+			"invalid": "invalid request parameters",
+		},
+		LangZh: {
+			"type":    "%s必须是%s类型",
+			"invalid": "请求参数格式错误",
+		},
+	}
+)
+
+// RegisterBindValidator registers the en and zh translations on the validator of gin (binding.Validator), the
+// fields are named after their json tag, or their form or uri tag when they have no json tag. It changes the
+// field names of every validation of gin, e.g. the errors of c.ShouldBind name the fields after their json tag.
+// The validator caches the names of a struct when it validates it first, call it before any validation:
+// server.BuildEngine calls it, the Bind helpers and ParamErrors call it as well. It only runs once, the messages
+// are not translated when binding.Validator is replaced by another validator.
+func RegisterBindValidator() {
+	_bindTranslatorOnce.Do(func() {
+		_bindTranslator = newBindTranslator()
+	})
+}
+
+func bindTranslator() *ut.UniversalTranslator {
+	RegisterBindValidator()
+	return _bindTranslator
+}
+
+func newBindTranslator() *ut.UniversalTranslator {
+	translator := ut.New(en.New(), en.New(), zh.New())
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return translator
+	}
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, key := range []string{"json", "form", "uri"} {
+			if name := tagName(field, key); name != "" && name != "-" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	enTrans, _ := translator.GetTranslator(LangEn)
+	zhTrans, _ := translator.GetTranslator(LangZh)
+	_ = entranslations.RegisterDefaultTranslations(v, enTrans)
+	_ = zhtranslations.RegisterDefaultTranslations(v, zhTrans)
+	return translator
+}
+
+// BindLang returns the language of the messages from the Accept-Language header, LangEn when it is neither zh nor en
+func BindLang(c *gin.Context) string {
+	for _, lang := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		lang = strings.ToLower(strings.TrimSpace(strings.SplitN(lang, ";", 2)[0]))
+		if strings.HasPrefix(lang, LangZh) {
+			return LangZh
+		}
+		if strings.HasPrefix(lang, LangEn) {
+			return LangEn
+		}
+	}
+	return LangEn
+}
+
+// BindJSON binds the json body into obj and validates its binding tags, on failure it writes
+// ErrorRequestParameter with the ParamErrors as Data and returns false
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	return BindWith(c, obj, binding.JSON)
+}
+
+// BindQuery is BindJSON for the query parameters, the fields are bound by their form tag
+func BindQuery(c *gin.Context, obj interface{}) bool {
+	return BindWith(c, obj, binding.Query)
+}
+
+// BindForm is BindJSON for the url encoded or multipart form and the query parameters
+func BindForm(c *gin.Context, obj interface{}) bool {
+	return BindWith(c, obj, binding.Form)
+}
+
+// BindURI is BindJSON for the path parameters, the fields are bound by their uri tag
+func BindURI(c *gin.Context, obj interface{}) bool {
+	RegisterBindValidator()
+	return bindResult(c, c.ShouldBindUri(obj))
+}
+
+// BindWith is BindJSON for another binding of gin, e.g. binding.XML
+func BindWith(c *gin.Context, obj interface{}, b binding.Binding) bool {
+	RegisterBindValidator()
+	return bindResult(c, c.ShouldBindWith(obj, b))
+}
+
+func bindResult(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	lang := BindLang(c)
+	params := ParamErrors(err, lang)
+	msg := bindMessages[lang]["invalid"]
+	if len(params) > 0 {
+		messages := make([]string, 0, len(params))
+		for _, param := range params {
+			messages = append(messages, param.Message)
+		}
+		msg = strings.Join(messages, "; ")
+	}
+	FailWithDetailed(ErrorRequestParameter, params, msg, c)
+	return false
+}
+
+// ParamErrors converts the error of a gin binding into ParamErrors with the messages in lang (LangEn or LangZh),
+// it is empty when the request cannot be decoded at all, e.g. malformed json
+func ParamErrors(err error, lang string) []ParamError {
+	if _, ok := bindMessages[lang]; !ok {
+		lang = LangEn
+	}
+	params := make([]ParamError, 0)
+	var sliceErrors binding.SliceValidationError
+	if errors.As(err, &sliceErrors) {
+		for _, e := range sliceErrors {
+			params = append(params, ParamErrors(e, lang)...)
+		}
+		return params
+	}
+	var fieldErrors validator.ValidationErrors
+	if errors.As(err, &fieldErrors) {
+		trans, _ := bindTranslator().GetTranslator(lang)
+		for _, fe := range fieldErrors {
+			params = append(params, ParamError{Field: paramFieldPath(fe), Tag: fe.Tag(), Message: fe.Translate(trans)})
+		}
+		return params
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) && typeError.Field != "" {
+		return append(params, ParamError{
+			Field:   typeError.Field,
+			Tag:     "type",
+			Message: fmt.Sprintf(bindMessages[lang]["type"], typeError.Field, typeError.Type.Kind()),
+		})
+	}
+	return params
+}
+
+// paramFieldPath returns the field without the root struct, e.g. items[0].name
+func paramFieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if idx := strings.Index(ns, "."); idx >= 0 {
+		return ns[idx+1:]
+	}
+	return ns
+}
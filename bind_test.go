@@ -0,0 +1,101 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/assert"
+)
+
+type bindItem struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type bindRequest struct {
+	PageSize int        `json:"page_size" form:"page_size" binding:"min=1,max=100"`
+	Email    string     `json:"email" binding:"omitempty,email"`
+	Items    []bindItem `json:"items" binding:"dive"`
+	Keyword  string     `form:"keyword" binding:"required"`
+}
+
+type bindResponse struct {
+	Code int          `json:"code"`
+	Data []ParamError `json:"data"`
+	Msg  string       `json:"msg"`
+}
+
+func TestBind(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/json", func(c *gin.Context) {
+		var req bindRequest
+		if BindJSON(c, &req) {
+			Ok(c)
+		}
+	})
+	engine.GET("/query", func(c *gin.Context) {
+		var req bindRequest
+		if BindQuery(c, &req) {
+			OkWithData(req.Keyword, c)
+		}
+	})
+	do := func(method, url, body, lang string) bindResponse {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp bindResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/json", `{"page_size":0,"email":"x","items":[{"name":""}]}`, "en-US,en;q=0.9")
+	assert.Equal(t, ErrorRequestParameter, resp.Code)
+	assert.Equal(t, []ParamError{
+		{Field: "page_size", Tag: "min", Message: "page_size must be 1 or greater"},
+		{Field: "email", Tag: "email", Message: "email must be a valid email address"},
+		{Field: "items[0].name", Tag: "required", Message: "name is a required field"},
+		{Field: "keyword", Tag: "required", Message: "keyword is a required field"},
+	}, resp.Data)
+	assert.True(t, strings.HasPrefix(resp.Msg, "page_size must be 1 or greater; "))
+
+	resp = do(http.MethodPost, "/json", `{"page_size":"ten","keyword":"a"}`, "zh-CN")
+	assert.Equal(t, []ParamError{{Field: "page_size", Tag: "type", Message: "page_size必须是int类型"}}, resp.Data)
+
+	resp = do(http.MethodPost, "/json", `{`, "zh-CN")
+	assert.Equal(t, ErrorRequestParameter, resp.Code)
+	assert.Equal(t, "请求参数格式错误", resp.Msg)
+
+	resp = do(http.MethodGet, "/query?page_size=101", "", "zh-CN,zh;q=0.9")
+	assert.Equal(t, []ParamError{
+		{Field: "page_size", Tag: "max", Message: "page_size必须小于或等于100"},
+		{Field: "keyword", Tag: "required", Message: "keyword为必填字段"},
+	}, resp.Data)
+}
+
+func TestBindAfterValidation(t *testing.T) {
+	type login struct {
+		UserName string `json:"user_name" binding:"required"`
+	}
+	//the struct is validated by gin before the first Bind, e.g. with c.ShouldBind in a handler,
+	//its names are cached by the validator: they are registered before by BuildEngine
+	RegisterBindValidator()
+	assert.NotNil(t, binding.Validator.ValidateStruct(&login{}))
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	assert.False(t, BindJSON(c, &login{}))
+	var resp bindResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []ParamError{{Field: "user_name", Tag: "required", Message: "user_name is a required field"}}, resp.Data)
+}
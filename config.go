@@ -2,10 +2,7 @@ package common
 
 import (
 	"fmt"
-	"github.com/fsnotify/fsnotify"
-	"github.com/spf13/viper"
-	"github.com/tmnhs/common/utils"
-	"path"
+	"time"
 )
 
 const (
@@ -27,61 +24,134 @@ var (
 
 type (
 	Mysql struct {
-		Path         string `mapstructure:"path" json:"path" yaml:"path" ini:"path"`                                       // 服务器地址
-		Port         string `mapstructure:"port" json:"port" yaml:"port" ini:"port"`                                       // 端口
-		Config       string `mapstructure:"config" json:"config" yaml:"config" ini:"config"`                               // 高级配置
-		Dbname       string `mapstructure:"db-name" json:"dbname" yaml:"db-name" ini:"db-name"`                            // 数据库名
-		Username     string `mapstructure:"username" json:"username" yaml:"username" ini:"username"`                       // 数据库用户名
-		Password     string `mapstructure:"password" json:"password" yaml:"password" ini:"password"`                       // 数据库密码
-		MaxIdleConns int    `mapstructure:"max-idle-conns" json:"maxIdleConns" yaml:"max-idle-conns" ini:"max-idle-conns"` // 空闲中的最大连接数
-		MaxOpenConns int    `mapstructure:"max-open-conns" json:"maxOpenConns" yaml:"max-open-conns" ini:"max-open-conns"` // 打开到数据库的最大连接数
-		LogMode      string `mapstructure:"log-mode" json:"logMode" yaml:"log-mode" ini:"log-mode"`                        // 是否开启Gorm全局日志
-		LogZap       bool   `mapstructure:"log-zap" json:"logZap" yaml:"log-zap" ini:"log-zap"`                            // 是否通过zap写入日志文件
+		Path         string `mapstructure:"path" json:"path" yaml:"path" ini:"path" desc:"MySQL host"`                                                                                              // 服务器地址
+		Port         string `mapstructure:"port" json:"port" yaml:"port" ini:"port" default:"3306" validate:"numeric" desc:"MySQL port"`                                                            // 端口
+		Config       string `mapstructure:"config" json:"config" yaml:"config" ini:"config" default:"charset=utf8mb4&parseTime=True&loc=Local" desc:"DSN parameters appended after ?"`              // 高级配置
+		Dbname       string `mapstructure:"db-name" json:"dbname" yaml:"db-name" ini:"db-name" validate:"required_with=Path" desc:"database name, created if it does not exist"`                    // 数据库名
+		Username     string `mapstructure:"username" json:"username" yaml:"username" ini:"username" desc:"MySQL user"`                                                                              // 数据库用户名
+		Password     string `mapstructure:"password" json:"password" yaml:"password" ini:"password" secret:"true" desc:"MySQL password"`                                                            // 数据库密码
+		MaxIdleConns int    `mapstructure:"max-idle-conns" json:"maxIdleConns" yaml:"max-idle-conns" ini:"max-idle-conns" default:"10" validate:"min=0" desc:"maximum number of idle connections"`  // 空闲中的最大连接数
+		MaxOpenConns int    `mapstructure:"max-open-conns" json:"maxOpenConns" yaml:"max-open-conns" ini:"max-open-conns" default:"100" validate:"min=0" desc:"maximum number of open connections"` // 打开到数据库的最大连接数
+		LogMode      string `mapstructure:"log-mode" json:"logMode" yaml:"log-mode" ini:"log-mode" validate:"omitempty,oneof=silent Silent error Error warn Warn info Info" desc:"gorm log level"`  // 是否开启Gorm全局日志
+		LogZap       bool   `mapstructure:"log-zap" json:"logZap" yaml:"log-zap" ini:"log-zap" desc:"write gorm logs through zap"`                                                                  // 是否通过zap写入日志文件
 	}
 	Etcd struct {
-		Endpoints   []string `mapstructure:"endpoints" json:"endpoints" yaml:"endpoints" ini:"endpoints"`
-		Username    string   `mapstructure:"username" json:"username" yaml:"username" ini:"username"`
-		Password    string   `mapstructure:"password" json:"password" yaml:"password" ini:"password"`
-		DialTimeout int64    `mapstructure:"dial-timeout" json:"dial-timeout" yaml:"dial-timeout" ini:"dial-timeout"`
-		ReqTimeout  int64    `mapstructure:"req-timeout" json:"req-timeout" yaml:"req-timeout" ini:"req-timeout"`
+		Endpoints   []string `mapstructure:"endpoints" json:"endpoints" yaml:"endpoints" ini:"endpoints" validate:"dive,url|hostname_port" desc:"etcd endpoints, url or host:port"`
+		Username    string   `mapstructure:"username" json:"username" yaml:"username" ini:"username" desc:"etcd user"`
+		Password    string   `mapstructure:"password" json:"password" yaml:"password" ini:"password" secret:"true" desc:"etcd password"`
+		DialTimeout int64    `mapstructure:"dial-timeout" json:"dial-timeout" yaml:"dial-timeout" ini:"dial-timeout" default:"5" validate:"min=0" desc:"dial timeout in seconds"`
+		ReqTimeout  int64    `mapstructure:"req-timeout" json:"req-timeout" yaml:"req-timeout" ini:"req-timeout" default:"5" validate:"min=0" desc:"request timeout in seconds"`
 	}
 	Redis struct {
-		DB       int    `mapstructure:"db" json:"db" yaml:"db" ini:"db"`                         // redis的哪个数据库
-		Addr     string `mapstructure:"addr" json:"addr" yaml:"addr" ini:"addr"`                 // 服务器地址:端口
-		Password string `mapstructure:"password" json:"password" yaml:"password" ini:"password"` // 密码
+		DB       int    `mapstructure:"db" json:"db" yaml:"db" ini:"db" validate:"min=0" desc:"redis database index"`                      // redis的哪个数据库
+		Addr     string `mapstructure:"addr" json:"addr" yaml:"addr" ini:"addr" validate:"omitempty,hostname_port" desc:"redis host:port"` // 服务器地址:端口
+		Password string `mapstructure:"password" json:"password" yaml:"password" ini:"password" secret:"true" desc:"redis password"`       // 密码
 	}
 	System struct {
-		Env        string `mapstructure:"env" json:"env" yaml:"env" ini:"env"`
-		Addr       int    `mapstructure:"addr" json:"addr" yaml:"addr" ini:"addr"`
-		UploadType string `mapstructure:"upload-type" json:"upload-type" yaml:"upload-type" ini:"upload-type"` // Oss类型
-		Version    string `mapstructure:"version" json:"version" yaml:"version" ini:"version"`
+		Env        string    `mapstructure:"env" json:"env" yaml:"env" ini:"env" desc:"environment name"`
+		Addr       int       `mapstructure:"addr" json:"addr" yaml:"addr" ini:"addr" default:"8080" validate:"min=1,max=65535" desc:"http listen port"`
+		UploadType string    `mapstructure:"upload-type" json:"upload-type" yaml:"upload-type" ini:"upload-type" validate:"omitempty,oneof=local qiniu aliyun-oss hua-wei-obs tencent-cos" desc:"storage used by the upload helpers"` // Oss类型
+		Version    string    `mapstructure:"version" json:"version" yaml:"version" ini:"version" desc:"service version"`
+		Cors       Cors      `mapstructure:"cors" json:"cors" yaml:"cors" ini:"cors"`                         // 跨域
+		RateLimit  RateLimit `mapstructure:"rate-limit" json:"rate-limit" yaml:"rate-limit" ini:"rate-limit"` // 限流
+		Registry   Registry  `mapstructure:"registry" json:"registry" yaml:"registry" ini:"registry"`         // 服务注册
+		Tls        Tls       `mapstructure:"tls" json:"tls" yaml:"tls" ini:"tls"`                             // https
+	}
+	RateLimit struct {
+		Enable    bool            `mapstructure:"enable" json:"enable" yaml:"enable" ini:"enable" desc:"enable the rate limiting middleware"`                                                                                                                     // 开启限流
+		Store     string          `mapstructure:"store" json:"store" yaml:"store" ini:"store" default:"local" validate:"omitempty,oneof=local redis" desc:"local: in-process token buckets, redis: buckets shared by every instance"`                             // 限流存储
+		Rate      float64         `mapstructure:"rate" json:"rate" yaml:"rate" ini:"rate" validate:"min=0" desc:"default requests per second of each key, 0 disables the default limit"`                                                                          // 默认每秒请求数
+		Burst     int             `mapstructure:"burst" json:"burst" yaml:"burst" ini:"burst" validate:"min=0" desc:"default bucket size, defaults to the rate rounded up"`                                                                                       // 默认桶大小
+		KeyBy     string          `mapstructure:"key-by" json:"key-by" yaml:"key-by" ini:"key-by" default:"ip" validate:"omitempty,oneof=ip user api-key" desc:"default key of the buckets: client ip, user id or api key"`                                       // 默认限流维度
+		UserKey   string          `mapstructure:"user-key" json:"user-key" yaml:"user-key" ini:"user-key" default:"user-id" desc:"gin context key holding the user id, requests without it are keyed by ip"`                                                      // 用户ID在gin上下文中的key
+		APIHeader string          `mapstructure:"api-header" json:"api-header" yaml:"api-header" ini:"api-header" default:"X-Api-Key" desc:"header holding the api key, requests without it are keyed by ip"`                                                     // API Key请求头
+		Routes    []RateLimitRule `mapstructure:"routes" json:"routes" yaml:"routes" ini:"routes" validate:"dive" desc:"per-route limits, the first matching route wins over the default limit"`                                                                  // 路由限流规则
+		KeyPrefix string          `mapstructure:"key-prefix" json:"key-prefix" yaml:"key-prefix" ini:"key-prefix" desc:"prefix of the bucket keys in redis, defaults to <registry.name>:rate-limit: so that the services sharing a redis have their own buckets"` // 限流key前缀
+	}
+	Jwt struct {
+		Issuer           string        `mapstructure:"issuer" json:"issuer" yaml:"issuer" ini:"issuer" desc:"iss of the issued tokens, checked when set"`                                                    // 签发者
+		Audience         string        `mapstructure:"audience" json:"audience" yaml:"audience" ini:"audience" desc:"aud of the issued tokens, checked when set"`                                            // 接收方
+		ActiveKid        string        `mapstructure:"active-kid" json:"active-kid" yaml:"active-kid" ini:"active-kid" desc:"kid of the key signing new tokens, defaults to the first key"`                  // 签名使用的密钥
+		Keys             []JwtKey      `mapstructure:"keys" json:"keys" yaml:"keys" ini:"keys" validate:"dive" desc:"signing and verification keys, keep the retired keys until their tokens expire"`        // 密钥
+		ExpiresIn        time.Duration `mapstructure:"expires-in" json:"expires-in" yaml:"expires-in" ini:"expires-in" default:"2h" desc:"lifetime of the access tokens"`                                    // access token有效期
+		RefreshExpiresIn time.Duration `mapstructure:"refresh-expires-in" json:"refresh-expires-in" yaml:"refresh-expires-in" ini:"refresh-expires-in" default:"168h" desc:"lifetime of the refresh tokens"` // refresh token有效期
+		Leeway           time.Duration `mapstructure:"leeway" json:"leeway" yaml:"leeway" ini:"leeway" default:"30s" desc:"clock skew tolerated when checking exp, nbf and iat"`                             // 时间误差
+		Revocation       bool          `mapstructure:"revocation" json:"revocation" yaml:"revocation" ini:"revocation" desc:"keep the revoked tokens in redis and reject them"`                              // 开启token吊销(redis)
+	}
+	JwtKey struct {
+		Kid        string `mapstructure:"kid" json:"kid" yaml:"kid" ini:"kid" validate:"required" desc:"key id, written in the kid header"`                                                                              // 密钥ID
+		Algorithm  string `mapstructure:"algorithm" json:"algorithm" yaml:"algorithm" ini:"algorithm" validate:"oneof=HS256 HS384 HS512 RS256 RS384 RS512" desc:"signing algorithm"`                                     // 签名算法
+		Secret     string `mapstructure:"secret" json:"secret" yaml:"secret" ini:"secret" secret:"true" desc:"secret of the HS algorithms"`                                                                              // HS密钥
+		PrivateKey string `mapstructure:"private-key" json:"private-key" yaml:"private-key" ini:"private-key" secret:"true" desc:"PEM private key of the RS algorithms, e.g. ${file:conf/jwt.key}, only needed to sign"` // RS私钥
+		PublicKey  string `mapstructure:"public-key" json:"public-key" yaml:"public-key" ini:"public-key" desc:"PEM public key of the RS algorithms"`                                                                    // RS公钥
+	}
+	RateLimitRule struct {
+		Route string  `mapstructure:"route" json:"route" yaml:"route" ini:"route" validate:"required" desc:"route template with an optional method, a trailing * matches a prefix, e.g. POST /api/v1/login or /api/v1/*"` // 路由
+		Rate  float64 `mapstructure:"rate" json:"rate" yaml:"rate" ini:"rate" validate:"min=0" desc:"requests per second of each key, 0 means unlimited"`                                                                 // 每秒请求数
+		Burst int     `mapstructure:"burst" json:"burst" yaml:"burst" ini:"burst" validate:"min=0" desc:"bucket size, defaults to the rate rounded up"`                                                                   // 桶大小
+		KeyBy string  `mapstructure:"key-by" json:"key-by" yaml:"key-by" ini:"key-by" validate:"omitempty,oneof=ip user api-key" desc:"key of the buckets, defaults to rate-limit.key-by"`                                // 限流维度
+	}
+	Tls struct {
+		Enable       bool     `mapstructure:"enable" json:"enable" yaml:"enable" ini:"enable" desc:"serve https on system.addr"`                                                                                                                                                                // 开启https
+		CertFile     string   `mapstructure:"cert-file" json:"cert-file" yaml:"cert-file" ini:"cert-file" validate:"required_if=Enable true" desc:"certificate chain in PEM, reloaded when the file changes"`                                                                                   // 证书
+		KeyFile      string   `mapstructure:"key-file" json:"key-file" yaml:"key-file" ini:"key-file" validate:"required_if=Enable true" desc:"private key in PEM, reloaded when the file changes"`                                                                                             // 私钥
+		MinVersion   string   `mapstructure:"min-version" json:"min-version" yaml:"min-version" ini:"min-version" default:"1.2" validate:"omitempty,oneof=1.0 1.1 1.2 1.3" desc:"minimum tls version"`                                                                                          // 最低版本
+		CipherSuites []string `mapstructure:"cipher-suites" json:"cipher-suites" yaml:"cipher-suites" ini:"cipher-suites" desc:"cipher suites of tls 1.0-1.2 named as in crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, the go defaults when empty"`                                   // 加密套件
+		ClientCaFile string   `mapstructure:"client-ca-file" json:"client-ca-file" yaml:"client-ca-file" ini:"client-ca-file" desc:"CA bundle verifying the client certificates, enables mutual tls, reloaded when the file changes"`                                                           // 客户端证书的CA
+		ClientAuth   string   `mapstructure:"client-auth" json:"client-auth" yaml:"client-auth" ini:"client-auth" default:"require" validate:"omitempty,oneof=require verify-if-given" desc:"require: every client presents a valid certificate, verify-if-given: the certificate is optional"` // 客户端证书校验方式
+	}
+	Registry struct {
+		Name   string `mapstructure:"name" json:"name" yaml:"name" ini:"name" desc:"service name of the instances, required by server.WithRegistry"`                                                     // 服务名
+		Prefix string `mapstructure:"prefix" json:"prefix" yaml:"prefix" ini:"prefix" default:"/common/services/" desc:"etcd key prefix, the instances are registered under <prefix><name>/<host:port>"` // key前缀
+		Host   string `mapstructure:"host" json:"host" yaml:"host" ini:"host" validate:"omitempty,hostname|ip" desc:"advertised host, the local ip when empty"`                                          // 注册的地址
+		Port   int    `mapstructure:"port" json:"port" yaml:"port" ini:"port" validate:"min=0,max=65535" desc:"advertised port, the listen port when 0"`                                                 // 注册的端口
+		Ttl    int64  `mapstructure:"ttl" json:"ttl" yaml:"ttl" ini:"ttl" default:"10" validate:"min=0" desc:"lease ttl in seconds"`                                                                     // 租约时间
+		Weight int    `mapstructure:"weight" json:"weight" yaml:"weight" ini:"weight" default:"100" validate:"min=0" desc:"load balancing weight"`                                                       // 权重
+		Zone   string `mapstructure:"zone" json:"zone" yaml:"zone" ini:"zone" desc:"zone or data center of the instance"`                                                                                // 可用区
+	}
+	Cors struct {
+		AllowAll         bool          `mapstructure:"allow-all" json:"allow-all" yaml:"allow-all" ini:"allow-all" desc:"allow every origin, refused in production"`                                                                                                                 // 允许所有来源(生产环境不可用)
+		AllowOrigins     []string      `mapstructure:"allow-origins" json:"allow-origins" yaml:"allow-origins" ini:"allow-origins" desc:"allowed origins: exact (https://a.com), wildcard subdomain (https://*.a.com) or regex (regex:^https://a[0-9]+\\.com$)"`                     // 允许的来源
+		AllowMethods     []string      `mapstructure:"allow-methods" json:"allow-methods" yaml:"allow-methods" ini:"allow-methods" default:"GET,POST,PUT,PATCH,DELETE,HEAD,OPTIONS" desc:"allowed methods"`                                                                          // 允许的方法
+		AllowHeaders     []string      `mapstructure:"allow-headers" json:"allow-headers" yaml:"allow-headers" ini:"allow-headers" default:"Content-Type,Authorization,Token,AccessToken,X-CSRF-Token,X-User-Id,X-Request-Id,traceparent,tracestate" desc:"allowed request headers"` // 允许的请求头
+		ExposeHeaders    []string      `mapstructure:"expose-headers" json:"expose-headers" yaml:"expose-headers" ini:"expose-headers" default:"Content-Length,Content-Type,X-Request-Id,traceparent" desc:"response headers readable by the browser"`                               // 暴露的响应头
+		MaxAge           time.Duration `mapstructure:"max-age" json:"max-age" yaml:"max-age" ini:"max-age" default:"10m" desc:"how long the browser caches a preflight response"`                                                                                                    // 预检缓存时间
+		AllowCredentials bool          `mapstructure:"allow-credentials" json:"allow-credentials" yaml:"allow-credentials" ini:"allow-credentials" desc:"allow cookies and Authorization headers"`                                                                                   // 允许携带凭证
 	}
 	Log struct {
-		Level         string `mapstructure:"level" json:"level" yaml:"level" ini:"level"`                                    // 级别
-		Format        string `mapstructure:"format" json:"format" yaml:"format" ini:"level"`                                 // 输出
-		Prefix        string `mapstructure:"prefix" json:"prefix" yaml:"prefix" ini:"level"`                                 // 日志前缀
-		Director      string `mapstructure:"director" json:"director"  yaml:"director" ini:"level"`                          // 日志文件夹
-		ShowLine      bool   `mapstructure:"show-line" json:"showLine" yaml:"showLine" ini:"showLine"`                       // 显示行
-		EncodeLevel   string `mapstructure:"encode-level" json:"encodeLevel" yaml:"encode-level" ini:"encode-level"`         // 编码级
-		StacktraceKey string `mapstructure:"stacktrace-key" json:"stacktraceKey" yaml:"stacktrace-key" ini:"stacktrace-key"` // 栈名
-		LogInConsole  bool   `mapstructure:"log-in-console" json:"logInConsole" yaml:"log-in-console" ini:"log-in-console"`  // 输出控制台
+		Level         string    `mapstructure:"level" json:"level" yaml:"level" ini:"level" default:"debug" validate:"oneof=debug info warn error" desc:"minimum log level"`                                                                                                                    // 级别
+		Format        string    `mapstructure:"format" json:"format" yaml:"format" ini:"format" default:"console" validate:"oneof=console json" desc:"log encoding"`                                                                                                                            // 输出
+		Prefix        string    `mapstructure:"prefix" json:"prefix" yaml:"prefix" ini:"prefix" desc:"prefix of the log time"`                                                                                                                                                                  // 日志前缀
+		Director      string    `mapstructure:"director" json:"director" yaml:"director" ini:"director" default:"logs" validate:"required" desc:"directory of the log files"`                                                                                                                   // 日志文件夹
+		ShowLine      bool      `mapstructure:"show-line" json:"showLine" yaml:"show-line" ini:"show-line" desc:"log the caller line"`                                                                                                                                                          // 显示行
+		EncodeLevel   string    `mapstructure:"encode-level" json:"encodeLevel" yaml:"encode-level" ini:"encode-level" default:"LowercaseLevelEncoder" validate:"oneof=LowercaseLevelEncoder LowercaseColorLevelEncoder CapitalLevelEncoder CapitalColorLevelEncoder" desc:"zap level encoder"` // 编码级
+		StacktraceKey string    `mapstructure:"stacktrace-key" json:"stacktraceKey" yaml:"stacktrace-key" ini:"stacktrace-key" default:"stacktrace" desc:"key of the stacktrace field"`                                                                                                         // 栈名
+		LogInConsole  bool      `mapstructure:"log-in-console" json:"logInConsole" yaml:"log-in-console" ini:"log-in-console" desc:"also write logs to stdout"`                                                                                                                                 // 输出控制台
+		Access        AccessLog `mapstructure:"access" json:"access" yaml:"access" ini:"access"`                                                                                                                                                                                                // 访问日志
+	}
+	AccessLog struct {
+		Enable        bool          `mapstructure:"enable" json:"enable" yaml:"enable" ini:"enable" desc:"write one log entry per http request"`                                                                                       // 开启访问日志
+		ExcludePaths  []string      `mapstructure:"exclude-paths" json:"exclude-paths" yaml:"exclude-paths" ini:"exclude-paths" desc:"paths that are not logged, a trailing * matches a prefix, e.g. /health or /static/*"`            // 不记录的路径
+		SampleRoutes  []string      `mapstructure:"sample-routes" json:"sample-routes" yaml:"sample-routes" ini:"sample-routes" desc:"route templates that are sampled, e.g. /api/v1/user/:id"`                                        // 采样的路由
+		SampleRate    float64       `mapstructure:"sample-rate" json:"sample-rate" yaml:"sample-rate" ini:"sample-rate" default:"1" validate:"min=0,max=1" desc:"fraction of the requests of the sample routes that are logged"`       // 采样比例
+		SlowThreshold time.Duration `mapstructure:"slow-threshold" json:"slow-threshold" yaml:"slow-threshold" ini:"slow-threshold" default:"1s" desc:"requests slower than this are logged at warn level, 0 disables it, e.g. 500ms"` // 慢请求阈值
 	}
 )
 
 //notify
 type (
 	Email struct {
-		Port     int      `mapstructure:"port" json:"port" yaml:"port" ini:"port"`                 // 端口
-		From     string   `mapstructure:"from" json:"from" yaml:"from" ini:"from"`                 // 收件人
-		Host     string   `mapstructure:"host" json:"host" yaml:"host" ini:"host"`                 // 服务器地址
-		IsSSL    bool     `mapstructure:"is-ssl" json:"is-ssl" yaml:"is-ssl" ini:"is-ssl"`         // 是否SSL
-		Secret   string   `mapstructure:"secret" json:"secret" yaml:"secret" ini:"secret"`         // 密钥
-		Nickname string   `mapstructure:"nickname" json:"nickname" yaml:"nickname" ini:"nickname"` // 昵称
-		To       []string `mapstructure:"to" json:"to" yaml:"to" ini:"to"`
+		Port     int      `mapstructure:"port" json:"port" yaml:"port" ini:"port" validate:"omitempty,min=1,max=65535" desc:"SMTP port"` // 端口
+		From     string   `mapstructure:"from" json:"from" yaml:"from" ini:"from" validate:"omitempty,email" desc:"sender address"`      // 收件人
+		Host     string   `mapstructure:"host" json:"host" yaml:"host" ini:"host" validate:"omitempty,hostname" desc:"SMTP host"`        // 服务器地址
+		IsSSL    bool     `mapstructure:"is-ssl" json:"is-ssl" yaml:"is-ssl" ini:"is-ssl" desc:"use SSL"`                                // 是否SSL
+		Secret   string   `mapstructure:"secret" json:"secret" yaml:"secret" ini:"secret" secret:"true" desc:"SMTP password"`            // 密钥
+		Nickname string   `mapstructure:"nickname" json:"nickname" yaml:"nickname" ini:"nickname" desc:"sender display name"`            // 昵称
+		To       []string `mapstructure:"to" json:"to" yaml:"to" ini:"to" validate:"dive,email" desc:"default recipients"`
 	}
 	WebHook struct {
-		Kind string `mapstructure:"kind" json:"kind" yaml:"kind" ini:"kind"`
-		Url  string `mapstructure:"url" json:"url" yaml:"url" ini:"kind"`
+		Kind string `mapstructure:"kind" json:"kind" yaml:"kind" ini:"kind" desc:"webhook kind, e.g. feishu"`
+		Url  string `mapstructure:"url" json:"url" yaml:"url" ini:"url" validate:"omitempty,url" secret:"true" desc:"webhook url"`
 	}
 	Notify struct {
 		Email   Email   `mapstructure:"email" json:"email" yaml:"email" ini:"email"`
@@ -93,42 +163,42 @@ type (
 type (
 	//阿里云存储对象
 	AliyunOSS struct {
-		Endpoint        string `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint" ini:"endpoint"`
-		AccessKeyId     string `mapstructure:"access-key-id" json:"access-key-id" yaml:"access-key-id" ini:"access-key-id"`
-		AccessKeySecret string `mapstructure:"access-key-secret" json:"access-key-secret" yaml:"access-key-secret" ini:"access-key-secret"`
-		BucketName      string `mapstructure:"bucket-name" json:"bucket-name" yaml:"bucket-name" ini:"bucket-name"`
-		BucketUrl       string `mapstructure:"bucket-url" json:"bucket-url" yaml:"bucket-url" ini:"bucket-url"`
-		BasePath        string `mapstructure:"base-path" json:"base-path" yaml:"base-path" ini:"base-path"`
+		Endpoint        string `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint" ini:"endpoint" desc:"OSS endpoint"`
+		AccessKeyId     string `mapstructure:"access-key-id" json:"access-key-id" yaml:"access-key-id" ini:"access-key-id" secret:"true" desc:"access key id"`
+		AccessKeySecret string `mapstructure:"access-key-secret" json:"access-key-secret" yaml:"access-key-secret" ini:"access-key-secret" secret:"true" desc:"access key secret"`
+		BucketName      string `mapstructure:"bucket-name" json:"bucket-name" yaml:"bucket-name" ini:"bucket-name" desc:"bucket name"`
+		BucketUrl       string `mapstructure:"bucket-url" json:"bucket-url" yaml:"bucket-url" ini:"bucket-url" validate:"omitempty,url" desc:"public url of the bucket"`
+		BasePath        string `mapstructure:"base-path" json:"base-path" yaml:"base-path" ini:"base-path" desc:"key prefix of uploaded files"`
 	}
 	//华为云存储对象
 	HuaWeiObs struct {
-		Path      string `mapstructure:"path" json:"path" yaml:"path" ini:"path"`
-		Bucket    string `mapstructure:"bucket" json:"bucket" yaml:"bucket" ini:"bucket"`
-		Endpoint  string `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint" ini:"endpoint"`
-		AccessKey string `mapstructure:"access-key" json:"access-key" yaml:"access-key" ini:"access-key"`
-		SecretKey string `mapstructure:"secret-key" json:"secret-key" yaml:"secret-key" ini:"secret-key"`
+		Path      string `mapstructure:"path" json:"path" yaml:"path" ini:"path" desc:"public url of the bucket"`
+		Bucket    string `mapstructure:"bucket" json:"bucket" yaml:"bucket" ini:"bucket" desc:"bucket name"`
+		Endpoint  string `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint" ini:"endpoint" desc:"OBS endpoint"`
+		AccessKey string `mapstructure:"access-key" json:"access-key" yaml:"access-key" ini:"access-key" secret:"true" desc:"access key"`
+		SecretKey string `mapstructure:"secret-key" json:"secret-key" yaml:"secret-key" ini:"secret-key" secret:"true" desc:"secret key"`
 	}
 	Local struct {
-		Path string `mapstructure:"path" json:"path" yaml:"path" ini:"path"` // 本地文件路径
+		Path string `mapstructure:"path" json:"path" yaml:"path" ini:"path" desc:"directory of uploaded files"` // 本地文件路径
 	}
 	//七牛云存对象
 	Qiniu struct {
-		Zone          string `mapstructure:"zone" json:"zone" yaml:"zone" ini:"zone"`                                             // 存储区域
-		Bucket        string `mapstructure:"bucket" json:"bucket" yaml:"bucket" ini:"bucket"`                                     // 空间名称
-		ImgPath       string `mapstructure:"img-path" json:"img-path" yaml:"img-path" ini:"img-path"`                             // CDN加速域名
-		UseHTTPS      bool   `mapstructure:"use-https" json:"use-https" yaml:"use-https" ini:"use-https"`                         // 是否使用https
-		AccessKey     string `mapstructure:"access-key" json:"access-key" yaml:"access-key" ini:"access-key"`                     // 秘钥AK
-		SecretKey     string `mapstructure:"secret-key" json:"secret-key" yaml:"secret-key" ini:"secret-key"`                     // 秘钥SK
-		UseCdnDomains bool   `mapstructure:"use-cdn-domains" json:"use-cdn-domains" yaml:"use-cdn-domains" ini:"use-cdn-domains"` // 上传是否使用CDN上传加速
+		Zone          string `mapstructure:"zone" json:"zone" yaml:"zone" ini:"zone" validate:"omitempty,oneof=ZoneHuadong ZoneHuabei ZoneHuanan ZoneBeimei ZoneXinjiapo" desc:"storage zone"` // 存储区域
+		Bucket        string `mapstructure:"bucket" json:"bucket" yaml:"bucket" ini:"bucket" desc:"bucket name"`                                                                               // 空间名称
+		ImgPath       string `mapstructure:"img-path" json:"img-path" yaml:"img-path" ini:"img-path" validate:"omitempty,url" desc:"CDN domain"`                                               // CDN加速域名
+		UseHTTPS      bool   `mapstructure:"use-https" json:"use-https" yaml:"use-https" ini:"use-https" desc:"use https"`                                                                     // 是否使用https
+		AccessKey     string `mapstructure:"access-key" json:"access-key" yaml:"access-key" ini:"access-key" secret:"true" desc:"access key"`                                                  // 秘钥AK
+		SecretKey     string `mapstructure:"secret-key" json:"secret-key" yaml:"secret-key" ini:"secret-key" secret:"true" desc:"secret key"`                                                  // 秘钥SK
+		UseCdnDomains bool   `mapstructure:"use-cdn-domains" json:"use-cdn-domains" yaml:"use-cdn-domains" ini:"use-cdn-domains" desc:"upload through the CDN"`                                // 上传是否使用CDN上传加速
 	}
 	//腾讯云存储对象
 	TencentCOS struct {
-		Bucket     string `mapstructure:"bucket" json:"bucket" yaml:"bucket" ini:"bucket"`
-		Region     string `mapstructure:"region" json:"region" yaml:"region" ini:"region"`
-		SecretID   string `mapstructure:"secret-id" json:"secret-id" yaml:"secret-id" ini:"secret-id"`
-		SecretKey  string `mapstructure:"secret-key" json:"secret-key" yaml:"secret-key" ini:"secret-key"`
-		BaseURL    string `mapstructure:"base-url" json:"base-url" yaml:"base-url" ini:"base-url"`
-		PathPrefix string `mapstructure:"path-prefix" json:"path-prefix" yaml:"path-prefix" ini:"path-prefix"`
+		Bucket     string `mapstructure:"bucket" json:"bucket" yaml:"bucket" ini:"bucket" desc:"bucket name"`
+		Region     string `mapstructure:"region" json:"region" yaml:"region" ini:"region" desc:"bucket region"`
+		SecretID   string `mapstructure:"secret-id" json:"secret-id" yaml:"secret-id" ini:"secret-id" secret:"true" desc:"secret id"`
+		SecretKey  string `mapstructure:"secret-key" json:"secret-key" yaml:"secret-key" ini:"secret-key" secret:"true" desc:"secret key"`
+		BaseURL    string `mapstructure:"base-url" json:"base-url" yaml:"base-url" ini:"base-url" validate:"omitempty,url" desc:"public url of the bucket"`
+		PathPrefix string `mapstructure:"path-prefix" json:"path-prefix" yaml:"path-prefix" ini:"path-prefix" desc:"key prefix of uploaded files"`
 	}
 	Upload struct {
 		// oss
@@ -148,6 +218,12 @@ type Config struct {
 	Etcd   Etcd   `mapstructure:"etcd" json:"etcd" yaml:"etcd" ini:"etcd"`
 	Notify Notify `mapstructure:"notify" json:"notify" yaml:"notify" ini:"notify"`
 	Upload Upload `mapstructure:"upload" json:"upload" yaml:"upload" ini:"upload"`
+	Jwt    Jwt    `mapstructure:"jwt" json:"jwt" yaml:"jwt" ini:"jwt"`
+
+	//source of every effective value, see Origins
+	origins map[string]string
+	//application sections, see RegisterConfigSection
+	sections map[string]interface{}
 }
 
 func (m *Mysql) Dsn() string {
@@ -164,43 +240,20 @@ func (m *Mysql) EmptyDsn() string {
 	return fmt.Sprintf("%s:%s@tcp(%s:%s)/", m.Username, m.Password, m.Path, m.Port)
 }
 
-var _defaultConfig *Config
-
-func LoadConfig(env, configFileName string) (*Config, error) {
-	var c Config
-	var confPath string
-	dir := fmt.Sprintf("%s/%s", nameSpace, env)
-	for _, registerExt := range autoLoadLocalConfigs {
-		confPath = path.Join(dir, configFileName+registerExt)
-		if utils.Exists(confPath) {
-			break
-		}
-	}
-	fmt.Println("the path to the configuration file you are using is :", confPath)
-	v := viper.New()
-	v.SetConfigFile(confPath)
-	ext := utils.Ext(confPath)
-	v.SetConfigType(ext)
-	err := v.ReadInConfig()
-	if err != nil {
-		panic(fmt.Errorf("Fatal error config file: %s \n", err))
-	}
-	v.WatchConfig()
-
-	v.OnConfigChange(func(e fsnotify.Event) {
-		fmt.Println("config file changed:", e.Name)
-		if err := v.Unmarshal(&c); err != nil {
-			fmt.Println(err)
-		}
-	})
-	if err := v.Unmarshal(&c); err != nil {
-		fmt.Println(err)
-	}
-	fmt.Printf("load config is :%#v\n", c)
-	_defaultConfig = &c
-	return &c, nil
+// LoadConfig loads <config dir of env>/<configFileName>.<ext>, conf/<env> by default, and watches it,
+// every change of the file or of a remote source is decoded into a fresh snapshot that replaces the current one
+func LoadConfig(env, configFileName string, opts ...ConfigOption) (*Config, error) {
+	loader := &configLoader{
+		env:  env,
+		name: configFileName,
+	}
+	for _, opt := range opts {
+		opt(loader)
+	}
+	return loader.start()
 }
 
+// GetConfigModels returns the current Config snapshot
 func GetConfigModels() *Config {
-	return _defaultConfig
+	return loadConfig()
 }
@@ -0,0 +1,163 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/utils"
+)
+
+// ConfigChangeEvent is emitted through utils.EmitEvent after a reload changed the config, the argument is a *ConfigDiff
+const ConfigChangeEvent = "config-change"
+
+// ConfigFieldChange is the change of one config key, the values of secret fields are masked
+type ConfigFieldChange struct {
+	Key    string      `json:"key"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+	Source string      `json:"source"` // where the new value comes from, see Config.Origins
+}
+
+func (c ConfigFieldChange) String() string {
+	return fmt.Sprintf("%s: %v => %v (%s)", c.Key, formatConfigValue(c.Old), formatConfigValue(c.New), c.Source)
+}
+
+// ConfigDiff lists the changed keys of a reload, sorted by key
+type ConfigDiff struct {
+	Time    time.Time           `json:"time"`
+	Changes []ConfigFieldChange `json:"changes"`
+}
+
+func (d *ConfigDiff) String() string {
+	lines := make([]string, 0, len(d.Changes))
+	for _, change := range d.Changes {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffConfig compares two snapshots key by key, including the application sections.
+// A changed secret is reported with SecretMask as old and new value.
+func DiffConfig(old, new *Config) []ConfigFieldChange {
+	oldValues, secrets := configValues(old)
+	newValues, newSecrets := configValues(new)
+	for k := range newSecrets {
+		secrets[k] = true
+	}
+
+	keys := make(map[string]struct{}, len(newValues))
+	for k := range oldValues {
+		keys[k] = struct{}{}
+	}
+	for k := range newValues {
+		keys[k] = struct{}{}
+	}
+
+	var changes []ConfigFieldChange
+	for k := range keys {
+		oldValue, newValue := oldValues[k], newValues[k]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if secrets[k] {
+			oldValue, newValue = maskConfigValue(oldValue), maskConfigValue(newValue)
+		}
+		changes = append(changes, ConfigFieldChange{Key: k, Old: oldValue, New: newValue, Source: new.origins[k]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// reportConfigChanges writes the diff of a reload to the log and emits ConfigChangeEvent
+func reportConfigChanges(old, new *Config) {
+	if old == nil {
+		return
+	}
+	changes := DiffConfig(old, new)
+	if len(changes) == 0 {
+		return
+	}
+	diff := &ConfigDiff{Time: time.Now(), Changes: changes}
+	logConfigInfo(fmt.Sprintf("config reloaded, %d key(s) changed:\n%s", len(changes), diff.String()))
+	utils.EmitEvent(ConfigChangeEvent, diff)
+}
+
+// logConfigInfo logs through the zap logger once it is initialized, the config is loaded before it
+func logConfigInfo(msg string) {
+	if l := logger.GetLogger(); l != nil {
+		l.Info(msg)
+		return
+	}
+	fmt.Println(msg)
+}
+
+func logConfigError(msg string) {
+	if l := logger.GetLogger(); l != nil {
+		l.Error(msg)
+		return
+	}
+	fmt.Println(msg)
+}
+
+// configValues flattens a snapshot into dotted keys and reports which keys are secrets
+func configValues(c *Config) (map[string]interface{}, map[string]bool) {
+	values := make(map[string]interface{})
+	secrets := make(map[string]bool)
+	if c == nil {
+		return values, secrets
+	}
+	flattenStruct("", reflect.ValueOf(c).Elem(), values, secrets)
+	for name, section := range c.sections {
+		flattenStruct(name, reflect.Indirect(reflect.ValueOf(section)), values, secrets)
+	}
+	return values, secrets
+}
+
+func flattenStruct(prefix string, v reflect.Value, values map[string]interface{}, secrets map[string]bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := tagName(field, "mapstructure")
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		value := reflect.Indirect(v.Field(i))
+		switch {
+		case value.Kind() == reflect.Struct:
+			flattenStruct(key, value, values, secrets)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			for j := 0; j < value.Len(); j++ {
+				flattenStruct(fmt.Sprintf("%s[%d]", key, j), value.Index(j), values, secrets)
+			}
+		case value.IsValid():
+			values[key] = value.Interface()
+			if field.Tag.Get("secret") == "true" {
+				secrets[key] = true
+			}
+		}
+	}
+}
+
+func maskConfigValue(v interface{}) interface{} {
+	if v == nil || reflect.ValueOf(v).IsZero() {
+		return ""
+	}
+	return SecretMask
+}
+
+func formatConfigValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
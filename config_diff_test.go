@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common/utils"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := validConfig()
+	old.Mysql.Password = "old-password"
+	new := validConfig()
+	new.Mysql.Password = "new-password"
+	new.Log.Level = "error"
+	new.Etcd.Endpoints = []string{"127.0.0.1:2379"}
+	new.origins = map[string]string{"log.level": originEnv + "COMMON_LOG_LEVEL"}
+
+	changes := DiffConfig(old, new)
+	assert.Equal(t, []ConfigFieldChange{
+		{Key: "etcd.endpoints", Old: []string{"http://127.0.0.1:2379", "127.0.0.1:2379"}, New: []string{"127.0.0.1:2379"}},
+		{Key: "log.level", Old: "info", New: "error", Source: "env:COMMON_LOG_LEVEL"},
+		{Key: "mysql.password", Old: SecretMask, New: SecretMask},
+	}, changes)
+	assert.Empty(t, DiffConfig(old, old))
+
+	var emitted *ConfigDiff
+	onChange := func(arg interface{}) { emitted, _ = arg.(*ConfigDiff) }
+	assert.Nil(t, utils.OnEvent(ConfigChangeEvent, onChange))
+	defer utils.OffEvent(ConfigChangeEvent, onChange)
+	reportConfigChanges(old, new)
+	assert.NotNil(t, emitted)
+	assert.Len(t, emitted.Changes, 3)
+	assert.NotContains(t, emitted.String(), "new-password")
+}
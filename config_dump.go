@@ -0,0 +1,72 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// SecretMask replaces the values of the fields tagged `secret:"true"`
+const SecretMask = "******"
+
+// RedactConfig converts a config struct, including the application sections of a *Config,
+// into a map keyed by the mapstructure names,
+// the non-empty values of fields tagged `secret:"true"` are replaced by SecretMask
+func RedactConfig(c interface{}) map[string]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(c))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	m := redactStruct(v)
+	if config, ok := c.(*Config); ok {
+		for name, section := range config.sections {
+			m[name] = RedactConfig(section)
+		}
+	}
+	return m
+}
+
+// DumpConfig returns the redacted config as indented json, safe to print or log
+func DumpConfig(c interface{}) string {
+	data, err := json.MarshalIndent(RedactConfig(c), "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func redactStruct(v reflect.Value) map[string]interface{} {
+	t := v.Type()
+	m := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := tagName(field, "mapstructure")
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		value := reflect.Indirect(v.Field(i))
+		switch {
+		case field.Tag.Get("secret") == "true":
+			if value.IsValid() && !value.IsZero() {
+				m[name] = SecretMask
+			} else {
+				m[name] = ""
+			}
+		case value.Kind() == reflect.Struct:
+			m[name] = redactStruct(value)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			items := make([]interface{}, value.Len())
+			for j := range items {
+				items[j] = redactStruct(value.Index(j))
+			}
+			m[name] = items
+		case value.IsValid():
+			m[name] = value.Interface()
+		default:
+			m[name] = nil
+		}
+	}
+	return m
+}
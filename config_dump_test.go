@@ -0,0 +1,22 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpConfig(t *testing.T) {
+	c := &Config{
+		Mysql:  Mysql{Username: "root", Password: "mysql-password"},
+		Upload: Upload{AliyunOSS: AliyunOSS{AccessKeyId: "id", AccessKeySecret: "secret"}},
+	}
+	dump := DumpConfig(c)
+	assert.NotContains(t, dump, "mysql-password")
+	assert.NotContains(t, dump, `: "secret"`)
+	assert.Contains(t, dump, `"username": "root"`)
+
+	redacted := RedactConfig(c)
+	assert.Equal(t, SecretMask, redacted["mysql"].(map[string]interface{})["password"])
+	assert.Equal(t, "", redacted["redis"].(map[string]interface{})["password"])
+}
@@ -0,0 +1,403 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/tmnhs/common/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigEnvPrefix is the default prefix of the environment variables overriding config values,
+// e.g. mysql.password is overridden by COMMON_MYSQL_PASSWORD
+const ConfigEnvPrefix = "COMMON"
+
+// Name of the optional file every environment inherits from, conf/base.(json|yaml|ini)
+const baseConfigName = "base"
+
+// Names of the sources a config value can come from, from the lowest to the highest precedence:
+// defaults < files (base < env < includes) < remote source < environment variable < command-line.
+// Maps are merged key by key, any other value, including slices such as etcd.endpoints, is replaced as a whole.
+const (
+	originDefault = "default"
+	originFile    = "file:"
+	originEnv     = "env:"
+	originFlag    = "flag"
+)
+
+// ConfigSource is a remote store of the Config document, its values take precedence over the local file
+type ConfigSource interface {
+	// Name is used in log messages and config origins
+	Name() string
+	// Read returns the document and its format (json/yaml/ini), an empty format means the format of the local file.
+	// local is the config decoded from the local file, e.g. to find the endpoints of the remote store.
+	Read(local *Config) (data []byte, format string, err error)
+	// Watch calls onChange every time the remote document is updated
+	Watch(onChange func()) error
+}
+
+// ConfigOrigin tells which source supplied the effective value of a config key
+type ConfigOrigin struct {
+	Key    string
+	Source string
+}
+
+type ConfigOption func(*configLoader)
+
+// WithConfigSource reads the config from a remote source as well,
+// the local file is used alone when the source is unreachable
+func WithConfigSource(source ConfigSource) ConfigOption {
+	return func(l *configLoader) {
+		l.sources = append(l.sources, source)
+	}
+}
+
+// WithConfigIncludes merges extra files over conf/<env>/<name>, in order, e.g. conf/testing/local.yaml
+func WithConfigIncludes(paths ...string) ConfigOption {
+	return func(l *configLoader) {
+		l.includes = append(l.includes, paths...)
+	}
+}
+
+// WithConfigEnvPrefix changes the prefix of the overriding environment variables, ConfigEnvPrefix by default
+func WithConfigEnvPrefix(prefix string) ConfigOption {
+	return func(l *configLoader) {
+		l.envPrefix = prefix
+	}
+}
+
+// WithConfigOverrides sets config values from the command-line, e.g. {"mysql.password": "xxx"},
+// they take precedence over every other source
+func WithConfigOverrides(overrides map[string]string) ConfigOption {
+	return func(l *configLoader) {
+		if l.overrides == nil {
+			l.overrides = make(map[string]string, len(overrides))
+		}
+		for k, v := range overrides {
+			l.overrides[strings.ToLower(k)] = v
+		}
+	}
+}
+
+// ConfigEnvName returns the environment variable overriding a config key,
+// e.g. upload.aliyun-oss.access-key-id => COMMON_UPLOAD_ALIYUN_OSS_ACCESS_KEY_ID
+func ConfigEnvName(prefix, key string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if prefix == "" {
+		return name
+	}
+	return strings.ToUpper(prefix) + "_" + name
+}
+
+// Origins lists the source of every effective config value, sorted by key
+func (c *Config) Origins() []ConfigOrigin {
+	origins := make([]ConfigOrigin, 0, len(c.origins))
+	for k, source := range c.origins {
+		origins = append(origins, ConfigOrigin{Key: k, Source: source})
+	}
+	sort.Slice(origins, func(i, j int) bool {
+		return origins[i].Key < origins[j].Key
+	})
+	return origins
+}
+
+// configLayer holds the flattened values supplied by one source
+type configLayer struct {
+	origin string
+	values map[string]interface{}
+}
+
+type configLoader struct {
+	env       string
+	name      string
+	envPrefix string
+	includes  []string
+	sources   []ConfigSource
+	overrides map[string]string
+	secretKey string
+	envFile   string
+
+	//serializes reloads triggered by the files and the remote sources
+	mu sync.Mutex
+	//local files from the lowest to the highest precedence: base, env file, includes,
+	//every load reads them into new vipers so that no viper is shared with a watcher goroutine
+	files []string
+}
+
+// the events of an editor saving a config file come in bursts, the files are read once they are quiet
+const configReloadDelay = 100 * time.Millisecond
+
+// findConfigFile returns dir/name with the first supported extension that exists, "" if there is none
+func findConfigFile(dir, name string) string {
+	for _, registerExt := range autoLoadLocalConfigs {
+		confPath := path.Join(dir, name+registerExt)
+		if utils.Exists(confPath) {
+			return confPath
+		}
+	}
+	return ""
+}
+
+// start reads the config, stores the first snapshot and starts watching all sources
+func (l *configLoader) start() (*Config, error) {
+	if l.envPrefix == "" {
+		l.envPrefix = ConfigEnvPrefix
+	}
+	dir := Environment(l.env).ConfigDir()
+	l.envFile = findConfigFile(dir, l.name)
+	if l.envFile == "" {
+		return nil, fmt.Errorf("config file %s/%s.(json|yaml|ini) not found", dir, l.name)
+	}
+	paths := []string{l.envFile}
+	if baseFile := findConfigFile(nameSpace, baseConfigName); baseFile != "" {
+		paths = append([]string{baseFile}, paths...)
+	}
+	paths = append(paths, l.includes...)
+	fmt.Println("the path to the configuration file you are using is :", strings.Join(paths, ", "))
+	l.files = paths
+
+	c, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	storeConfig(c)
+
+	if err := l.watch(); err != nil {
+		fmt.Printf("watch config files failed, the files are not reloaded, error:%s\n", err.Error())
+	}
+	for _, source := range l.sources {
+		if err := source.Watch(l.reload); err != nil {
+			fmt.Printf("watch config source %s failed, error:%s\n", source.Name(), err.Error())
+		}
+	}
+	return c, nil
+}
+
+// load merges all layers by precedence and decodes the result into a new validated snapshot
+func (l *configLoader) load() (*Config, error) {
+	keys := allConfigKeys()
+	layers := []configLayer{{origin: originDefault, values: configDefaults(keys)}}
+	for _, confPath := range l.files {
+		v := viper.New()
+		v.SetConfigFile(confPath)
+		v.SetConfigType(utils.Ext(confPath))
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("read config file %s failed: %w", confPath, err)
+		}
+		layers = append(layers, configLayer{origin: originFile + confPath, values: flattenSettings(v.AllSettings())})
+	}
+	local, _, err := l.decodeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, source := range l.sources {
+		data, format, err := source.Read(local)
+		if err != nil {
+			fmt.Printf("read config source %s failed, fall back to the local file, error:%s\n", source.Name(), err.Error())
+			continue
+		}
+		if format == "" {
+			format = utils.Ext(l.envFile)
+		}
+		remote := viper.New()
+		remote.SetConfigType(strings.TrimPrefix(format, "."))
+		if err := remote.ReadConfig(bytes.NewReader(data)); err != nil {
+			fmt.Printf("parse config source %s failed, fall back to the local file, error:%s\n", source.Name(), err.Error())
+			continue
+		}
+		layers = append(layers, configLayer{origin: source.Name(), values: flattenSettings(remote.AllSettings())})
+	}
+
+	for _, key := range keys {
+		name := ConfigEnvName(l.envPrefix, key.name)
+		if val, ok := os.LookupEnv(name); ok {
+			layers = append(layers, configLayer{origin: originEnv + name, values: map[string]interface{}{key.name: val}})
+		}
+	}
+	flag := configLayer{origin: originFlag, values: make(map[string]interface{}, len(l.overrides))}
+	for k, v := range l.overrides {
+		flag.values[k] = v
+	}
+	layers = append(layers, flag)
+
+	c, origins, err := l.decodeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateConfig(c); err != nil {
+		return nil, err
+	}
+	c.origins = origins
+	return c, nil
+}
+
+// watch reloads the config when a local file changes, the directories are watched so that
+// a rename or a kubernetes configmap update (a symlink swap) is noticed as well
+func (l *configLoader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, file := range l.files {
+		files[filepath.Clean(file)] = true
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if files[filepath.Clean(event.Name)] || filepath.Base(event.Name) == "..data" {
+					timer = time.After(configReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logConfigError(fmt.Sprintf("watch config files error:%s", err.Error()))
+			case <-timer:
+				timer = nil
+				fmt.Println("config file changed:", strings.Join(l.files, ", "))
+				l.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *configLoader) reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, err := l.load()
+	if err != nil {
+		logConfigError(fmt.Sprintf("reload config rejected, keep the previous config, error:%s", err.Error()))
+		return
+	}
+	old := loadConfig()
+	storeConfig(c)
+	reportConfigChanges(old, c)
+}
+
+// decodeLayers applies the layers in order, later layers win, records where each value came from
+// and resolves the secret references of the effective values
+func (l *configLoader) decodeLayers(layers []configLayer) (*Config, map[string]string, error) {
+	values := make(map[string]interface{})
+	origins := make(map[string]string)
+	for _, layer := range layers {
+		for k, val := range layer.values {
+			values[k] = val
+			origins[k] = layer.origin
+		}
+	}
+
+	v := viper.New()
+	var problems []string
+	for k, val := range values {
+		resolved, err := l.resolveSecret(val)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", k, err.Error()))
+			continue
+		}
+		v.Set(k, resolved)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, nil, &ConfigError{Problems: problems}
+	}
+
+	var c Config
+	if err := v.Unmarshal(&c); err != nil {
+		return nil, nil, fmt.Errorf("decode config failed: %w", err)
+	}
+	for _, section := range configSectionTypes() {
+		out := reflect.New(section.typ).Interface()
+		if err := v.UnmarshalKey(section.name, out); err != nil {
+			return nil, nil, fmt.Errorf("decode config section %s failed: %w", section.name, err)
+		}
+		if c.sections == nil {
+			c.sections = make(map[string]interface{})
+		}
+		c.sections[section.name] = out
+	}
+	return &c, origins, nil
+}
+
+// configKey is a leaf of the config tree, named by its dotted mapstructure path
+type configKey struct {
+	name  string
+	field reflect.StructField
+}
+
+// configKeys lists the leaf keys of a config struct, e.g. mysql.password
+func configKeys(t reflect.Type, prefix string) []configKey {
+	var keys []configKey
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := tagName(field, "mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(field.Type, name)...)
+			continue
+		}
+		keys = append(keys, configKey{name: name, field: field})
+	}
+	return keys
+}
+
+// configDefaults collects the values of the default struct tags
+func configDefaults(keys []configKey) map[string]interface{} {
+	defaults := make(map[string]interface{})
+	for _, key := range keys {
+		if val, ok := key.field.Tag.Lookup("default"); ok {
+			defaults[key.name] = val
+		}
+	}
+	return defaults
+}
+
+// flattenSettings turns nested settings into dotted keys, e.g. {"mysql": {"path": x}} => {"mysql.path": x}
+func flattenSettings(settings map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, val := range m {
+			key := strings.ToLower(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if nested, ok := val.(map[string]interface{}); ok {
+				walk(key, nested)
+				continue
+			}
+			flat[key] = val
+		}
+	}
+	walk("", settings)
+	return flat
+}
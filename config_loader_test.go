@@ -0,0 +1,192 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	resetConfigSnapshot(t)
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "conf", "testing"), os.ModePerm))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "conf", "testing", "main.yaml"), []byte(`
+mysql:
+  path: 10.0.0.1
+  db-name: test
+  username: file
+  password: file
+upload:
+  aliyun-oss:
+    access-key-id: file
+log:
+  access:
+    sample-routes: [/ping]
+`), 0644))
+	assert.Nil(t, os.Chdir(dir))
+
+	os.Setenv("COMMON_MYSQL_USERNAME", "env")
+	os.Setenv("COMMON_MYSQL_PASSWORD", "env")
+	os.Setenv("COMMON_UPLOAD_ALIYUN_OSS_ACCESS_KEY_ID", "env")
+	os.Setenv("COMMON_LOG_ACCESS_SLOW_THRESHOLD", "500ms")
+	defer os.Unsetenv("COMMON_LOG_ACCESS_SLOW_THRESHOLD")
+	defer os.Unsetenv("COMMON_MYSQL_USERNAME")
+	defer os.Unsetenv("COMMON_MYSQL_PASSWORD")
+	defer os.Unsetenv("COMMON_UPLOAD_ALIYUN_OSS_ACCESS_KEY_ID")
+
+	c, err := LoadConfig("testing", "main", WithConfigOverrides(map[string]string{"mysql.password": "flag"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "logs", c.Log.Director)
+	assert.Equal(t, "10.0.0.1", c.Mysql.Path)
+	assert.Equal(t, "env", c.Mysql.Username)
+	assert.Equal(t, "flag", c.Mysql.Password)
+	assert.Equal(t, "env", c.Upload.AliyunOSS.AccessKeyId)
+	assert.Equal(t, []string{"/ping"}, c.Log.Access.SampleRoutes)
+	assert.Equal(t, 1.0, c.Log.Access.SampleRate)
+	assert.Equal(t, 500*time.Millisecond, c.Log.Access.SlowThreshold)
+
+	origins := make(map[string]string)
+	for _, o := range c.Origins() {
+		origins[o.Key] = o.Source
+	}
+	assert.Equal(t, "default", origins["log.director"])
+	assert.Equal(t, "file:conf/testing/main.yaml", origins["mysql.path"])
+	assert.Equal(t, "env:COMMON_MYSQL_USERNAME", origins["mysql.username"])
+	assert.Equal(t, "flag", origins["mysql.password"])
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	resetConfigSnapshot(t)
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "conf", "production"), os.ModePerm))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "conf", "base.yaml"), []byte(`
+log:
+  level: info
+  prefix: "[base]"
+etcd:
+  endpoints: ["127.0.0.1:2379", "127.0.0.2:2379"]
+notify:
+  email:
+    to: ["a@test.com", "b@test.com"]
+`), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "conf", "production", "main.json"), []byte(`{
+  "log": {"level": "warn"},
+  "etcd": {"endpoints": ["10.0.0.1:2379"]}
+}`), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "conf", "production", "local.ini"), []byte(`
+[log]
+prefix = [local]
+`), 0644))
+	assert.Nil(t, os.Chdir(dir))
+
+	c, err := LoadConfig("production", "main", WithConfigIncludes("conf/production/local.ini"))
+	assert.Nil(t, err)
+	assert.Equal(t, "warn", c.Log.Level)
+	assert.Equal(t, "[local]", c.Log.Prefix)
+	assert.Equal(t, []string{"10.0.0.1:2379"}, c.Etcd.Endpoints, "slices are replaced, not appended")
+	assert.Equal(t, []string{"a@test.com", "b@test.com"}, c.Notify.Email.To)
+}
+
+type testFeature struct {
+	Threshold int    `mapstructure:"threshold" json:"threshold" yaml:"threshold" ini:"threshold" default:"10" validate:"min=1"`
+	Endpoint  string `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint" ini:"endpoint" validate:"omitempty,url"`
+	Token     string `mapstructure:"token" json:"token" yaml:"token" ini:"token" secret:"true"`
+}
+
+func TestLoadConfigSection(t *testing.T) {
+	resetConfigSnapshot(t)
+	assert.Nil(t, RegisterConfigSection("test-feature", testFeature{}))
+	assert.NotNil(t, RegisterConfigSection("test-feature", testFeature{}))
+	assert.NotNil(t, RegisterConfigSection("mysql", testFeature{}))
+	defer func() {
+		_configSections.Lock()
+		delete(_configSections.m, "test-feature")
+		_configSections.Unlock()
+	}()
+
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "conf", "testing"), os.ModePerm))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "conf", "testing", "main.yaml"), []byte(`
+test-feature:
+  endpoint: https://api.test.com
+  token: feature-token
+`), 0644))
+	assert.Nil(t, os.Chdir(dir))
+	os.Setenv("COMMON_TEST_FEATURE_THRESHOLD", "20")
+	defer os.Unsetenv("COMMON_TEST_FEATURE_THRESHOLD")
+
+	c, err := LoadConfig("testing", "main")
+	assert.Nil(t, err)
+	feature := c.Section("test-feature").(*testFeature)
+	assert.Equal(t, 20, feature.Threshold)
+	assert.Equal(t, "https://api.test.com", feature.Endpoint)
+	assert.NotContains(t, DumpConfig(c), "feature-token")
+
+	os.Setenv("COMMON_TEST_FEATURE_THRESHOLD", "0")
+	_, err = LoadConfig("testing", "main")
+	assert.EqualError(t, err, "invalid config, 1 problem(s):\n  - test-feature.threshold: must be at least 1, got 0")
+}
+
+// triggerSource is a ConfigSource without values, the test triggers its reloads
+type triggerSource struct {
+	onChange func()
+}
+
+func (s *triggerSource) Name() string { return "trigger" }
+
+func (s *triggerSource) Read(local *Config) ([]byte, string, error) {
+	return []byte("{}"), ".json", nil
+}
+
+func (s *triggerSource) Watch(onChange func()) error {
+	s.onChange = onChange
+	return nil
+}
+
+func TestLoadConfigReloadFile(t *testing.T) {
+	resetConfigSnapshot(t)
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	file := filepath.Join(dir, "conf", "testing", "main.yaml")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(file), os.ModePerm))
+	assert.Nil(t, ioutil.WriteFile(file, []byte("log:\n  level: info\n"), 0644))
+	assert.Nil(t, os.Chdir(dir))
+
+	source := &triggerSource{}
+	c, err := LoadConfig("testing", "main", WithConfigSource(source))
+	assert.Nil(t, err)
+	assert.Equal(t, "info", c.Log.Level)
+	levels := make(chan string, 100)
+	cancel := OnLogChange(func(old, new Log) { levels <- new.Level })
+	defer cancel()
+
+	//the reloads of the remote source run while the file is reloaded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			source.onChange()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	assert.Nil(t, ioutil.WriteFile(file, []byte("log:\n  level: error\n"), 0644))
+	select {
+	case level := <-levels:
+		assert.Equal(t, "error", level)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the config file is not reloaded")
+	}
+	<-done
+	assert.Equal(t, "error", GetConfigModels().Log.Level)
+}
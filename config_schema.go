@@ -0,0 +1,265 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// tags that must name a field like its mapstructure tag, the key used in config files,
+// the json tags name the fields of the json encoding of Config instead, e.g. maxIdleConns
+var configNameTags = []string{"yaml", "ini"}
+
+// jsonSchema is the subset of JSON Schema draft-07 generated from the config structs
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *float64               `json:"minLength,omitempty"`
+	MaxLength            *float64               `json:"maxLength,omitempty"`
+	MinItems             *float64               `json:"minItems,omitempty"`
+	MaxItems             *float64               `json:"maxItems,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+}
+
+// GenerateConfigSchema returns the JSON Schema of Config and of the registered application sections,
+// with the descriptions of the desc tags, the defaults of the default tags and the rules of the validate tags.
+// Unknown keys are rejected, which catches misspelled keys in yaml/json files.
+func GenerateConfigSchema() ([]byte, error) {
+	schema := structSchema(reflect.TypeOf(Config{}))
+	schema.Schema = "http://json-schema.org/draft-07/schema#"
+	schema.Title = ApiModule + " config"
+	for _, section := range configSectionTypes() {
+		schema.Properties[section.name] = structSchema(section.typ)
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// CheckConfigTags reports the fields of a config struct whose yaml/ini tags do not match the mapstructure tag
+func CheckConfigTags(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return []string{fmt.Sprintf("%v is not a struct", t)}
+	}
+	return checkStructTags(t, t.Name())
+}
+
+// CheckAllConfigTags runs CheckConfigTags on Config and on the registered application sections
+func CheckAllConfigTags() []string {
+	problems := CheckConfigTags(Config{})
+	for _, section := range configSectionTypes() {
+		problems = append(problems, checkStructTags(section.typ, section.name)...)
+	}
+	return problems
+}
+
+func checkStructTags(t reflect.Type, path string) []string {
+	var problems []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldPath := path + "." + field.Name
+		name := tagName(field, "mapstructure")
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("%s: missing mapstructure tag", fieldPath))
+		} else {
+			for _, key := range configNameTags {
+				if tag := tagName(field, key); tag != name {
+					problems = append(problems, fmt.Sprintf("%s: %s tag %q does not match mapstructure tag %q", fieldPath, key, tag, name))
+				}
+			}
+		}
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			problems = append(problems, checkStructTags(field.Type, fieldPath)...)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			problems = append(problems, checkStructTags(field.Type.Elem(), fieldPath+"[]")...)
+		}
+	}
+	return problems
+}
+
+func structSchema(t reflect.Type) *jsonSchema {
+	noAdditional := false
+	schema := &jsonSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*jsonSchema, t.NumField()),
+		AdditionalProperties: &noAdditional,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := tagName(field, "mapstructure")
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		property, required := fieldSchema(field)
+		schema.Properties[name] = property
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// fieldSchema returns the schema of a field and whether the field is required
+func fieldSchema(field reflect.StructField) (*jsonSchema, bool) {
+	schema := typeSchema(field.Type)
+	schema.Description = field.Tag.Get("desc")
+	if field.Tag.Get("secret") == "true" {
+		schema.Description = strings.TrimSpace(schema.Description + " (secret, may be ${env:NAME}, ${file:path} or enc:...)")
+	}
+	if def, ok := field.Tag.Lookup("default"); ok {
+		schema.Default = schemaValue(field.Type, def)
+	}
+
+	required := false
+	target := schema
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		name, param := rule, ""
+		if idx := strings.Index(rule, "="); idx >= 0 {
+			name, param = rule[:idx], rule[idx+1:]
+		}
+		switch name {
+		case "required":
+			//the default fills a missing key, e.g. in an env overlay file
+			_, hasDefault := field.Tag.Lookup("default")
+			required = !hasDefault
+		case "dive":
+			//the following rules apply to the items
+			if target.Items != nil {
+				target = target.Items
+			}
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				target.Enum = append(target.Enum, schemaValue(itemType(field.Type, target != schema), value))
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			switch target.Type {
+			case "string":
+				if name == "min" {
+					target.MinLength = &limit
+				} else {
+					target.MaxLength = &limit
+				}
+			case "array":
+				if name == "min" {
+					target.MinItems = &limit
+				} else {
+					target.MaxItems = &limit
+				}
+			default:
+				if name == "min" {
+					target.Minimum = &limit
+				} else {
+					target.Maximum = &limit
+				}
+			}
+		case "numeric":
+			target.Pattern = "^[0-9]+$"
+		default:
+			applyFormat(target, name)
+		}
+	}
+	return schema, required
+}
+
+// applyFormat maps format rules, alternatives such as url|hostname_port become anyOf
+func applyFormat(schema *jsonSchema, rule string) {
+	formats := map[string]*jsonSchema{
+		"url":           {Format: "uri"},
+		"email":         {Format: "email"},
+		"hostname":      {Format: "hostname"},
+		"hostname_port": {Pattern: `^[^:/\s]+:[0-9]+$`},
+	}
+	alternatives := strings.Split(rule, "|")
+	if len(alternatives) == 1 {
+		if f, ok := formats[rule]; ok {
+			schema.Format, schema.Pattern = f.Format, f.Pattern
+		}
+		return
+	}
+	for _, alternative := range alternatives {
+		if f, ok := formats[alternative]; ok {
+			schema.AnyOf = append(schema.AnyOf, f)
+		}
+	}
+}
+
+func typeSchema(t reflect.Type) *jsonSchema {
+	if t == durationType {
+		//decoded from strings such as 500ms or 1m30s
+		return &jsonSchema{Type: "string", Pattern: `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	default:
+		return &jsonSchema{Type: "string"}
+	}
+}
+
+func itemType(t reflect.Type, item bool) reflect.Type {
+	if item && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		return t.Elem()
+	}
+	return t
+}
+
+// schemaValue converts a tag value to the json type of the field
+func schemaValue(t reflect.Type, value string) interface{} {
+	switch typeSchema(t).Type {
+	case "integer":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case "array":
+		return strings.Split(value, ",")
+	}
+	return value
+}
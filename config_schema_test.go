@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfigTags(t *testing.T) {
+	assert.Empty(t, CheckConfigTags(Config{}))
+	//the json encoding of Config keeps its names
+	data, _ := json.Marshal(Mysql{})
+	assert.Contains(t, string(data), `"maxIdleConns"`)
+
+	type bad struct {
+		Level string `mapstructure:"level" json:"level" yaml:"level" ini:"format"`
+		Url   string `json:"url"`
+	}
+	problems := CheckConfigTags(&bad{})
+	assert.Len(t, problems, 2)
+	assert.Contains(t, problems[0], `bad.Level: ini tag "format"`)
+	assert.Contains(t, problems[1], "bad.Url: missing mapstructure tag")
+}
+
+func TestGenerateConfigSchema(t *testing.T) {
+	data, err := GenerateConfigSchema()
+	assert.Nil(t, err)
+
+	var schema struct {
+		Properties map[string]struct {
+			Properties map[string]struct {
+				Type        string        `json:"type"`
+				Description string        `json:"description"`
+				Default     interface{}   `json:"default"`
+				Enum        []interface{} `json:"enum"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		} `json:"properties"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &schema))
+
+	level := schema.Properties["log"].Properties["level"]
+	assert.Equal(t, "string", level.Type)
+	assert.NotEmpty(t, level.Description)
+	assert.Contains(t, level.Enum, "debug")
+
+	addr := schema.Properties["system"].Properties["addr"]
+	assert.Equal(t, "integer", addr.Type)
+	assert.NotNil(t, addr.Default)
+
+	//log.director is required but has a default, files may leave it out
+	assert.Equal(t, "logs", schema.Properties["log"].Properties["director"].Default)
+	assert.NotContains(t, schema.Properties["log"].Required, "director")
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Secret values inside config files:
+//
+//	${env:NAME}               replaced by the environment variable NAME
+//	${file:/run/secrets/x}    replaced by the content of the file, without the trailing newline
+//	enc:<ciphertext>          decrypted with the config key, see EncryptConfigValue
+const (
+	secretEncPrefix = "enc:"
+
+	// suffixes of the environment variables supplying the config key, e.g. COMMON_CONFIG_KEY
+	configKeyEnv     = "CONFIG_KEY"
+	configKeyFileEnv = "CONFIG_KEY_FILE"
+)
+
+var (
+	secretRefPattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+	ErrConfigKeyMissing = errors.New("config key is missing, set it with WithConfigKey or the COMMON_CONFIG_KEY/COMMON_CONFIG_KEY_FILE environment variable")
+)
+
+// WithConfigKey sets the key decrypting the enc: values,
+// by default it is read from the <prefix>_CONFIG_KEY or <prefix>_CONFIG_KEY_FILE environment variable
+func WithConfigKey(key string) ConfigOption {
+	return func(l *configLoader) {
+		l.secretKey = key
+	}
+}
+
+// EncryptConfigValue encrypts a value with AES-GCM, the result can be pasted into a config file as is
+func EncryptConfigValue(key, plaintext string) (string, error) {
+	gcm, err := newConfigCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretEncPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptConfigValue decrypts a value returned by EncryptConfigValue
+func DecryptConfigValue(key, value string) (string, error) {
+	gcm, err := newConfigCipher(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretEncPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decode encrypted value failed: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("decrypt value failed, the config key may be wrong")
+	}
+	return string(plaintext), nil
+}
+
+// newConfigCipher derives an AES-256 key from the config key
+func newConfigCipher(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, ErrConfigKeyMissing
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// configKey returns the key decrypting enc: values, from the option or the environment
+func (l *configLoader) configKey() (string, error) {
+	if l.secretKey != "" {
+		return l.secretKey, nil
+	}
+	if key, ok := os.LookupEnv(ConfigEnvName(l.envPrefix, configKeyEnv)); ok {
+		return key, nil
+	}
+	if file, ok := os.LookupEnv(ConfigEnvName(l.envPrefix, configKeyFileEnv)); ok {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read config key file failed: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return "", ErrConfigKeyMissing
+}
+
+// resolveSecret replaces the secret references of a string value, other values are returned as is
+func (l *configLoader) resolveSecret(value interface{}) (interface{}, error) {
+	switch val := value.(type) {
+	case string:
+		return l.resolveSecretString(val)
+	case []interface{}:
+		resolved := make([]interface{}, len(val))
+		for i, item := range val {
+			r, err := l.resolveSecret(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	case map[string]interface{}:
+		//items of a list of structs
+		resolved := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			r, err := l.resolveSecret(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = r
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+func (l *configLoader) resolveSecretString(value string) (string, error) {
+	if strings.HasPrefix(value, secretEncPrefix) {
+		key, err := l.configKey()
+		if err != nil {
+			return "", err
+		}
+		return DecryptConfigValue(key, value)
+	}
+
+	var resolveErr error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := secretRefPattern.FindStringSubmatch(ref)
+		switch match[1] {
+		case "env":
+			env, ok := os.LookupEnv(match[2])
+			if !ok {
+				resolveErr = fmt.Errorf("environment variable %s not found", match[2])
+			}
+			return env
+		default:
+			data, err := ioutil.ReadFile(match[2])
+			if err != nil {
+				resolveErr = fmt.Errorf("read secret file failed: %w", err)
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+	})
+	return resolved, resolveErr
+}
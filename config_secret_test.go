@@ -0,0 +1,53 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptConfigValue(t *testing.T) {
+	encrypted, err := EncryptConfigValue("my-key", "mysql-password")
+	assert.Nil(t, err)
+	assert.Contains(t, encrypted, "enc:")
+
+	plaintext, err := DecryptConfigValue("my-key", encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "mysql-password", plaintext)
+
+	_, err = DecryptConfigValue("other-key", encrypted)
+	assert.NotNil(t, err)
+}
+
+func TestResolveSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redis")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("redis-password\n"), 0600))
+	os.Setenv("TEST_SECRET_USER", "root")
+	defer os.Unsetenv("TEST_SECRET_USER")
+	encrypted, err := EncryptConfigValue("my-key", "mysql-password")
+	assert.Nil(t, err)
+
+	l := &configLoader{envPrefix: ConfigEnvPrefix, secretKey: "my-key"}
+	c, _, err := l.decodeLayers([]configLayer{{values: map[string]interface{}{
+		"mysql.username": "${env:TEST_SECRET_USER}",
+		"mysql.password": encrypted,
+		"redis.password": "${file:" + file + "}",
+		"redis.addr":     "127.0.0.1:6379",
+	}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "root", c.Mysql.Username)
+	assert.Equal(t, "mysql-password", c.Mysql.Password)
+	assert.Equal(t, "redis-password", c.Redis.Password)
+	assert.Equal(t, "127.0.0.1:6379", c.Redis.Addr)
+
+	l.secretKey = ""
+	_, _, err = l.decodeLayers([]configLayer{{values: map[string]interface{}{
+		"mysql.password": encrypted,
+		"email.secret":   "${env:TEST_SECRET_MISSING}",
+	}}})
+	assert.NotNil(t, err)
+	assert.Len(t, err.(*ConfigError).Problems, 2)
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registered application sections, name => struct type
+var _configSections = struct {
+	sync.RWMutex
+	m map[string]reflect.Type
+}{m: make(map[string]reflect.Type)}
+
+// RegisterConfigSection adds an application section to the config, it must be called before LoadConfig.
+// prototype is a struct or a pointer to a struct tagged like Config, e.g.
+//
+//	type Feature struct {
+//		Threshold int    `mapstructure:"threshold" json:"threshold" yaml:"threshold" ini:"threshold" default:"10" validate:"min=1"`
+//		Endpoint  string `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint" ini:"endpoint" validate:"omitempty,url"`
+//	}
+//	common.RegisterConfigSection("feature", Feature{})
+//
+// The section gets defaults, env overrides (COMMON_FEATURE_THRESHOLD), secrets, validation and hot reload
+// like the built-in ones, read it with Config.Section and subscribe to it with OnConfigChange("feature", ...).
+func RegisterConfigSection(name string, prototype interface{}) error {
+	name = strings.ToLower(name)
+	t := reflect.TypeOf(prototype)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("config section[%s] must be a struct", name)
+	}
+	if name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid config section name[%s]", name)
+	}
+	if configSection(&Config{}, name) != nil {
+		return fmt.Errorf("config section[%s] is built in", name)
+	}
+
+	_configSections.Lock()
+	defer _configSections.Unlock()
+	if _, ok := _configSections.m[name]; ok {
+		return fmt.Errorf("config section[%s] already exists", name)
+	}
+	_configSections.m[name] = t
+	return nil
+}
+
+// Section returns the decoded application section, a pointer to the registered struct type,
+// nil if the section is not registered. The value belongs to the snapshot and must not be modified.
+func (c *Config) Section(name string) interface{} {
+	return c.sections[strings.ToLower(name)]
+}
+
+type configSectionType struct {
+	name string
+	typ  reflect.Type
+}
+
+// configSectionTypes returns the registered sections sorted by name
+func configSectionTypes() []configSectionType {
+	_configSections.RLock()
+	defer _configSections.RUnlock()
+	sections := make([]configSectionType, 0, len(_configSections.m))
+	for name, t := range _configSections.m {
+		sections = append(sections, configSectionType{name: name, typ: t})
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].name < sections[j].name
+	})
+	return sections
+}
+
+// allConfigKeys lists the leaf keys of Config and of the registered sections
+func allConfigKeys() []configKey {
+	keys := configKeys(reflect.TypeOf(Config{}), "")
+	for _, section := range configSectionTypes() {
+		keys = append(keys, configKeys(section.typ, section.name)...)
+	}
+	return keys
+}
+
+// OnSectionChange subscribes to changes of an application section, old and new are pointers to its registered type
+func OnSectionChange(name string, f func(old, new interface{})) (cancel func()) {
+	return OnConfigChange(name, func(old, new *Config) { f(old.Section(name), new.Section(name)) })
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ConfigError aggregates every problem found while validating a config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config, %d problem(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+var (
+	_configValidator     *validator.Validate
+	_configValidatorOnce sync.Once
+)
+
+// configValidator validates the `validate` struct tags, fields are named after their mapstructure key
+func configValidator() *validator.Validate {
+	_configValidatorOnce.Do(func() {
+		_configValidator = validator.New()
+		_configValidator.RegisterTagNameFunc(func(field reflect.StructField) string {
+			if name := tagName(field, "mapstructure"); name != "" && name != "-" {
+				return name
+			}
+			return field.Name
+		})
+		_configValidator.RegisterStructValidation(validateUpload, Config{})
+	})
+	return _configValidator
+}
+
+// ValidateConfig checks the `validate` tags of the config and of its application sections,
+// it returns a *ConfigError listing every problem
+func ValidateConfig(c *Config) error {
+	problems, err := validateStruct("", c)
+	if err != nil {
+		return err
+	}
+	for _, section := range configSectionTypes() {
+		if value, ok := c.sections[section.name]; ok {
+			sectionProblems, err := validateStruct(section.name+".", value)
+			if err != nil {
+				return err
+			}
+			problems = append(problems, sectionProblems...)
+		}
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+func validateStruct(prefix string, v interface{}) ([]string, error) {
+	err := configValidator().Struct(v)
+	if err == nil {
+		return nil, nil
+	}
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return nil, err
+	}
+	problems := make([]string, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		problems = append(problems, fmt.Sprintf("%s%s: %s", prefix, configFieldPath(fe), configProblem(fe)))
+	}
+	return problems, nil
+}
+
+// configFieldPath returns the dotted key of the field without the root struct, e.g. mysql.db-name
+func configFieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if idx := strings.Index(ns, "."); idx >= 0 {
+		return ns[idx+1:]
+	}
+	return ns
+}
+
+func configProblem(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_with":
+		return fmt.Sprintf("is required when %s is set", strings.ToLower(fe.Param()))
+	case "required_if":
+		if param := strings.Fields(fe.Param()); len(param) == 2 {
+			return fmt.Sprintf("is required when %s is %s", strings.ToLower(param[0]), param[1])
+		}
+		return "is required"
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s], got %q", strings.Replace(fe.Param(), " ", ", ", -1), fmt.Sprint(fe.Value()))
+	case "min":
+		return fmt.Sprintf("must be at least %s, got %v", fe.Param(), fe.Value())
+	case "max":
+		return fmt.Sprintf("must be at most %s, got %v", fe.Param(), fe.Value())
+	case "numeric":
+		return fmt.Sprintf("must be numeric, got %q", fmt.Sprint(fe.Value()))
+	case "url":
+		return fmt.Sprintf("must be a url, got %q", fmt.Sprint(fe.Value()))
+	case "email":
+		return fmt.Sprintf("must be an email address, got %q", fmt.Sprint(fe.Value()))
+	case "hostname":
+		return fmt.Sprintf("must be a host name, got %q", fmt.Sprint(fe.Value()))
+	case "hostname_port":
+		return fmt.Sprintf("must be host:port, got %q", fmt.Sprint(fe.Value()))
+	case "url|hostname_port":
+		return fmt.Sprintf("must be a url or host:port, got %q", fmt.Sprint(fe.Value()))
+	default:
+		return fmt.Sprintf("failed on the %q rule, got %v", fe.Tag(), fe.Value())
+	}
+}
+
+// validateUpload requires the credentials of the storage selected by system.upload-type
+func validateUpload(sl validator.StructLevel) {
+	c := sl.Current().Interface().(Config)
+	required := func(value, field, name string) {
+		if value == "" {
+			sl.ReportError(value, "upload."+field+"."+name, name, "required", "")
+		}
+	}
+	switch c.System.UploadType {
+	case "local":
+		required(c.Upload.Local.Path, "local", "path")
+	case "qiniu":
+		required(c.Upload.Qiniu.Bucket, "qiniu", "bucket")
+		required(c.Upload.Qiniu.AccessKey, "qiniu", "access-key")
+		required(c.Upload.Qiniu.SecretKey, "qiniu", "secret-key")
+	case "aliyun-oss":
+		required(c.Upload.AliyunOSS.Endpoint, "aliyun-oss", "endpoint")
+		required(c.Upload.AliyunOSS.AccessKeyId, "aliyun-oss", "access-key-id")
+		required(c.Upload.AliyunOSS.AccessKeySecret, "aliyun-oss", "access-key-secret")
+		required(c.Upload.AliyunOSS.BucketName, "aliyun-oss", "bucket-name")
+	case "hua-wei-obs":
+		required(c.Upload.HuaWeiObs.Endpoint, "hua-wei-obs", "endpoint")
+		required(c.Upload.HuaWeiObs.Bucket, "hua-wei-obs", "bucket")
+		required(c.Upload.HuaWeiObs.AccessKey, "hua-wei-obs", "access-key")
+		required(c.Upload.HuaWeiObs.SecretKey, "hua-wei-obs", "secret-key")
+	case "tencent-cos":
+		required(c.Upload.TencentCOS.Bucket, "tencent-cos", "bucket")
+		required(c.Upload.TencentCOS.Region, "tencent-cos", "region")
+		required(c.Upload.TencentCOS.SecretID, "tencent-cos", "secret-id")
+		required(c.Upload.TencentCOS.SecretKey, "tencent-cos", "secret-key")
+	}
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Log:    Log{Level: "info", Format: "console", Director: "logs", EncodeLevel: "LowercaseLevelEncoder"},
+		System: System{Addr: 8080},
+		Mysql:  Mysql{Path: "127.0.0.1", Port: "3306", Dbname: "test"},
+		Etcd:   Etcd{Endpoints: []string{"http://127.0.0.1:2379", "127.0.0.1:2379"}},
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.Nil(t, ValidateConfig(validConfig()))
+
+	c := validConfig()
+	c.Mysql.Dbname = ""
+	c.System.Addr = 70000
+	c.System.UploadType = "qiniu"
+	c.Log.Level = "verbose"
+	c.Etcd.Endpoints = []string{"not a host"}
+	c.System.Tls.Enable = true
+	c.System.Tls.KeyFile = "server.key"
+	err := ValidateConfig(c)
+	assert.NotNil(t, err)
+
+	configErr, ok := err.(*ConfigError)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{
+		"mysql.db-name: is required when path is set",
+		"system.addr: must be at most 65535, got 70000",
+		`log.level: must be one of [debug, info, warn, error], got "verbose"`,
+		`etcd.endpoints[0]: must be a url or host:port, got "not a host"`,
+		"upload.qiniu.bucket: is required",
+		"upload.qiniu.access-key: is required",
+		"upload.qiniu.secret-key: is required",
+		"system.tls.cert-file: is required when enable is true",
+	}, configErr.Problems)
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Config sections, named after their mapstructure key
+const (
+	SectionLog    = "log"
+	SectionSystem = "system"
+	SectionMysql  = "mysql"
+	SectionRedis  = "redis"
+	SectionEtcd   = "etcd"
+	SectionNotify = "notify"
+	SectionUpload = "upload"
+	SectionJwt    = "jwt"
+)
+
+// ConfigChangeHandler is called with the previous and the new snapshot after a reload.
+// Snapshots are immutable, handlers must not modify them.
+type ConfigChangeHandler func(old, new *Config)
+
+type configSubscriber struct {
+	id      uint64
+	section string
+	handler ConfigChangeHandler
+}
+
+var (
+	//current Config snapshot, always a *Config
+	_configValue atomic.Value
+
+	_configSubscribers struct {
+		sync.RWMutex
+		list   []configSubscriber
+		nextID uint64
+	}
+)
+
+// OnConfigChange subscribes to changes of a config section,
+// the handler is only called when that section differs between the old and the new snapshot.
+// An empty section subscribes to every reload. The returned func unsubscribes the handler.
+func OnConfigChange(section string, handler ConfigChangeHandler) (cancel func()) {
+	if handler == nil {
+		return func() {}
+	}
+	_configSubscribers.Lock()
+	defer _configSubscribers.Unlock()
+	_configSubscribers.nextID++
+	id := _configSubscribers.nextID
+	_configSubscribers.list = append(_configSubscribers.list, configSubscriber{
+		id:      id,
+		section: strings.ToLower(section),
+		handler: handler,
+	})
+	return func() { removeConfigSubscriber(id) }
+}
+
+func removeConfigSubscriber(id uint64) {
+	_configSubscribers.Lock()
+	defer _configSubscribers.Unlock()
+	for i, s := range _configSubscribers.list {
+		if s.id == id {
+			_configSubscribers.list = append(_configSubscribers.list[:i], _configSubscribers.list[i+1:]...)
+			return
+		}
+	}
+}
+
+func OnLogChange(f func(old, new Log)) (cancel func()) {
+	return OnConfigChange(SectionLog, func(old, new *Config) { f(old.Log, new.Log) })
+}
+
+func OnSystemChange(f func(old, new System)) (cancel func()) {
+	return OnConfigChange(SectionSystem, func(old, new *Config) { f(old.System, new.System) })
+}
+
+func OnMysqlChange(f func(old, new Mysql)) (cancel func()) {
+	return OnConfigChange(SectionMysql, func(old, new *Config) { f(old.Mysql, new.Mysql) })
+}
+
+func OnRedisChange(f func(old, new Redis)) (cancel func()) {
+	return OnConfigChange(SectionRedis, func(old, new *Config) { f(old.Redis, new.Redis) })
+}
+
+func OnEtcdChange(f func(old, new Etcd)) (cancel func()) {
+	return OnConfigChange(SectionEtcd, func(old, new *Config) { f(old.Etcd, new.Etcd) })
+}
+
+func OnNotifyChange(f func(old, new Notify)) (cancel func()) {
+	return OnConfigChange(SectionNotify, func(old, new *Config) { f(old.Notify, new.Notify) })
+}
+
+func OnUploadChange(f func(old, new Upload)) (cancel func()) {
+	return OnConfigChange(SectionUpload, func(old, new *Config) { f(old.Upload, new.Upload) })
+}
+
+func OnJwtChange(f func(old, new Jwt)) (cancel func()) {
+	return OnConfigChange(SectionJwt, func(old, new *Config) { f(old.Jwt, new.Jwt) })
+}
+
+// storeConfig atomically swaps in a new snapshot and notifies the subscribers of every changed section
+func storeConfig(c *Config) {
+	old := loadConfig()
+	_configValue.Store(c)
+	if old == nil {
+		return
+	}
+
+	_configSubscribers.RLock()
+	subscribers := make([]configSubscriber, len(_configSubscribers.list))
+	copy(subscribers, _configSubscribers.list)
+	_configSubscribers.RUnlock()
+
+	for _, s := range subscribers {
+		if s.section == "" || !reflect.DeepEqual(configSection(old, s.section), configSection(c, s.section)) {
+			s.handler(old, c)
+		}
+	}
+}
+
+func loadConfig() *Config {
+	c, _ := _configValue.Load().(*Config)
+	return c
+}
+
+// configSection returns the value of the built-in section whose mapstructure key is name
+// or of the registered application section, nil if there is none
+func configSection(c *Config, name string) interface{} {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tagName(t.Field(i), "mapstructure") == name {
+			return v.Field(i).Interface()
+		}
+	}
+	if section, ok := c.sections[name]; ok {
+		return section
+	}
+	return nil
+}
+
+// tagName returns the name part of a struct tag, without options such as ",omitempty"
+func tagName(field reflect.StructField, key string) string {
+	tag := field.Tag.Get(key)
+	if idx := strings.Index(tag, ","); idx >= 0 {
+		tag = tag[:idx]
+	}
+	return tag
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// resetConfigSnapshot restores the current snapshot at the end of the test, so that the
+// snapshots of the tests which load config do not notify the subscribers of the next tests
+func resetConfigSnapshot(t *testing.T) {
+	previous := loadConfig()
+	t.Cleanup(func() { _configValue.Store(previous) })
+}
+
+func TestOnConfigChangeCancel(t *testing.T) {
+	resetConfigSnapshot(t)
+	var changes int
+	cancel := OnMysqlChange(func(old, new Mysql) {
+		changes++
+	})
+	storeConfig(&Config{Mysql: Mysql{Path: "10.0.0.1"}})
+	storeConfig(&Config{Mysql: Mysql{Path: "10.0.0.2"}})
+	cancel()
+	cancel()
+	storeConfig(&Config{Mysql: Mysql{Path: "10.0.0.3"}})
+	assert.Equal(t, 1, changes)
+}
+
+func TestOnConfigChange(t *testing.T) {
+	resetConfigSnapshot(t)
+	first := &Config{Log: Log{Level: "info"}, Redis: Redis{Addr: "127.0.0.1:6379"}}
+	storeConfig(first)
+	assert.Equal(t, first, GetConfigModels())
+
+	var logChanges, redisChanges, allChanges int
+	var oldLevel, newLevel string
+	cancelLog := OnLogChange(func(old, new Log) {
+		logChanges++
+		oldLevel, newLevel = old.Level, new.Level
+	})
+	defer cancelLog()
+	cancelRedis := OnRedisChange(func(old, new Redis) {
+		redisChanges++
+	})
+	defer cancelRedis()
+	cancelAll := OnConfigChange("", func(old, new *Config) {
+		allChanges++
+	})
+	defer cancelAll()
+
+	second := &Config{Log: Log{Level: "error"}, Redis: Redis{Addr: "127.0.0.1:6379"}}
+	storeConfig(second)
+	assert.Equal(t, second, GetConfigModels())
+	assert.Equal(t, "info", first.Log.Level, "old snapshot must not be mutated")
+
+	assert.Equal(t, 1, logChanges)
+	assert.Equal(t, "info", oldLevel)
+	assert.Equal(t, "error", newLevel)
+	assert.Equal(t, 0, redisChanges)
+	assert.Equal(t, 1, allChanges)
+}
@@ -2,15 +2,26 @@ package dbclient
 
 //mysql数据库连接
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/tmnhs/common/logger"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"sync"
 )
 
 var _defaultDB *gorm.DB
 
+var ErrMysqlNotInit = errors.New("mysql database is not initialized")
+
+//注册到每个db的插件，例如监控
+var _plugins []gorm.Plugin
+
+//保护_plugins以及Init/Set/Use对_defaultDB的替换
+var _pluginsMu sync.Mutex
+
 func Init(dsn, logMode string, maxIdleConns, maxOpenConns int) (*gorm.DB, error) {
 
 	mysqlConfig := mysql.Config{
@@ -24,6 +35,13 @@ func Init(dsn, logMode string, maxIdleConns, maxOpenConns int) (*gorm.DB, error)
 		sqlDB, _ := db.DB()
 		sqlDB.SetMaxIdleConns(maxIdleConns)
 		sqlDB.SetMaxOpenConns(maxOpenConns)
+		_pluginsMu.Lock()
+		defer _pluginsMu.Unlock()
+		for _, plugin := range _plugins {
+			if err := db.Use(plugin); err != nil {
+				return nil, err
+			}
+		}
 		_defaultDB = db
 		return db, nil
 	}
@@ -37,6 +55,33 @@ func GetMysqlDB() *gorm.DB {
 	return _defaultDB
 }
 
+//Set 替换默认db并返回之前的db，例如测试中使用的假数据库，Use注册的插件也会添加到db
+func Set(db *gorm.DB) (previous *gorm.DB) {
+	_pluginsMu.Lock()
+	defer _pluginsMu.Unlock()
+	previous = _defaultDB
+	if db != nil {
+		for _, plugin := range _plugins {
+			if err := db.Use(plugin); err != nil && !errors.Is(err, gorm.ErrRegistered) {
+				logger.GetLogger().Error(fmt.Sprintf("mysql:use plugin %s failed, error:%s", plugin.Name(), err.Error()))
+			}
+		}
+	}
+	_defaultDB = db
+	return previous
+}
+
+//Use 注册gorm插件，对已经初始化和之后初始化的db都生效
+func Use(plugin gorm.Plugin) error {
+	_pluginsMu.Lock()
+	defer _pluginsMu.Unlock()
+	_plugins = append(_plugins, plugin)
+	if _defaultDB != nil {
+		return _defaultDB.Use(plugin)
+	}
+	return nil
+}
+
 //to create database
 func CreateDatabase(dsn string, driver string, createSql string) error {
 	db, err := sql.Open(driver, dsn)
@@ -55,3 +100,28 @@ func CreateDatabase(dsn string, driver string, createSql string) error {
 	_, err = db.Exec(createSql)
 	return err
 }
+
+//Ping 检查mysql连接，用于健康检查
+func Ping(ctx context.Context) error {
+	if _defaultDB == nil {
+		return ErrMysqlNotInit
+	}
+	sqlDB, err := _defaultDB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+//Close 关闭mysql连接
+func Close() error {
+	if _defaultDB == nil {
+		return nil
+	}
+	sqlDB, err := _defaultDB.DB()
+	if err != nil {
+		return err
+	}
+	_defaultDB = nil
+	return sqlDB.Close()
+}
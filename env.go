@@ -2,17 +2,100 @@ package common
 
 import (
 	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
 	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 )
 
 //use export ENVIRONMENT=testing set global environment
 const (
-	EnvTesting    = Environment("testing")
-	EnvProduction = Environment("production")
+	EnvTesting     = Environment("testing")
+	EnvProduction  = Environment("production")
+	EnvDevelopment = Environment("development")
+	EnvStaging     = Environment("staging")
 )
 
 type Environment string
 
+// EnvironmentInfo describes a registered environment
+type EnvironmentInfo struct {
+	// ConfigDir holds the config files of the environment, conf/<env> by default
+	ConfigDir string
+	// GinMode is one of gin.DebugMode, gin.ReleaseMode, gin.TestMode
+	GinMode string
+	// Production environments get the strictest defaults, e.g. no permissive CORS
+	Production bool
+	// Kind is the built-in environment it behaves like for IsTesting, IsDevelopment, IsStaging and
+	// IsProduction, e.g. EnvStaging for a "pre" environment. EnvProduction when Production is set.
+	Kind Environment
+}
+
+var _environments = struct {
+	sync.RWMutex
+	m map[Environment]EnvironmentInfo
+}{
+	m: map[Environment]EnvironmentInfo{
+		EnvTesting:     {ConfigDir: path.Join(nameSpace, string(EnvTesting)), GinMode: gin.DebugMode, Kind: EnvTesting},
+		EnvDevelopment: {ConfigDir: path.Join(nameSpace, string(EnvDevelopment)), GinMode: gin.DebugMode, Kind: EnvDevelopment},
+		EnvStaging:     {ConfigDir: path.Join(nameSpace, string(EnvStaging)), GinMode: gin.ReleaseMode, Kind: EnvStaging},
+		EnvProduction:  {ConfigDir: path.Join(nameSpace, string(EnvProduction)), GinMode: gin.ReleaseMode, Production: true, Kind: EnvProduction},
+	},
+}
+
+// RegisterEnvironment adds or replaces an environment, an empty ConfigDir means conf/<env>
+// and an empty GinMode means gin.DebugMode
+func RegisterEnvironment(env Environment, info EnvironmentInfo) {
+	if info.ConfigDir == "" {
+		info.ConfigDir = path.Join(nameSpace, string(env))
+	}
+	if info.GinMode == "" {
+		info.GinMode = gin.DebugMode
+	}
+	if info.Production {
+		info.Kind = EnvProduction
+	}
+	if info.Kind == EnvProduction {
+		info.Production = true
+	}
+	_environments.Lock()
+	defer _environments.Unlock()
+	_environments.m[env] = info
+}
+
+// LookupEnvironment returns a registered environment, the unregistered ones are rejected by NewGlobalEnvironment
+// and Builder.Env, e.g. a per-developer environment has to be registered with RegisterEnvironment.
+func LookupEnvironment(env Environment) (EnvironmentInfo, bool) {
+	_environments.RLock()
+	defer _environments.RUnlock()
+	info, ok := _environments.m[env]
+	return info, ok
+}
+
+// info returns the registered environment, an unregistered one, e.g. a typo in the deployment,
+// behaves like production so that it never gets the permissive defaults of development
+func (env Environment) info() EnvironmentInfo {
+	if info, ok := LookupEnvironment(env); ok {
+		return info
+	}
+	return EnvironmentInfo{ConfigDir: path.Join(nameSpace, string(env)), GinMode: gin.ReleaseMode, Production: true, Kind: EnvProduction}
+}
+
+// Environments returns the names of the registered environments, sorted
+func Environments() []string {
+	_environments.RLock()
+	defer _environments.RUnlock()
+	names := make([]string, 0, len(_environments.m))
+	for env := range _environments.m {
+		names = append(names, string(env))
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (env *Environment) String() string {
 	return string(*env)
 }
@@ -26,7 +109,34 @@ func (env *Environment) Testing() Environment {
 }
 
 func (env Environment) Invalid() bool {
-	return env != EnvTesting && env != EnvProduction
+	_, ok := LookupEnvironment(env)
+	return !ok
+}
+
+func (env Environment) IsProduction() bool {
+	return env.info().Production
+}
+
+func (env Environment) IsTesting() bool {
+	return env.info().Kind == EnvTesting
+}
+
+func (env Environment) IsDevelopment() bool {
+	return env.info().Kind == EnvDevelopment
+}
+
+func (env Environment) IsStaging() bool {
+	return env.info().Kind == EnvStaging
+}
+
+// ConfigDir returns the config directory of the environment, conf/<env> if it is not registered
+func (env Environment) ConfigDir() string {
+	return env.info().ConfigDir
+}
+
+// GinMode returns the gin mode of the environment, gin.ReleaseMode if it is not registered
+func (env Environment) GinMode() string {
+	return env.info().GinMode
 }
 
 // NewGlobalEnvironment 读取系统全局配置的环境变量
@@ -37,8 +147,9 @@ func NewGlobalEnvironment() (Environment, error) {
 	}
 
 	env := Environment(environment)
-	if env != EnvTesting && env != EnvProduction {
-		return "", errors.New("environment not support, must be production, testing, development")
+	if env.Invalid() {
+		return "", fmt.Errorf("environment %s not support, must be one of %s or registered with common.RegisterEnvironment",
+			env, strings.Join(Environments(), ", "))
 	}
 
 	return env, nil
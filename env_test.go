@@ -0,0 +1,71 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterEnvironment(t *testing.T) {
+	defer func() {
+		_environments.Lock()
+		delete(_environments.m, "pre")
+		delete(_environments.m, "prod-eu")
+		_environments.Unlock()
+	}()
+	RegisterEnvironment("pre", EnvironmentInfo{GinMode: gin.ReleaseMode, Kind: EnvStaging})
+	RegisterEnvironment("prod-eu", EnvironmentInfo{ConfigDir: "conf/production", Production: true})
+
+	pre := Environment("pre")
+	assert.False(t, pre.Invalid())
+	assert.True(t, pre.IsStaging())
+	assert.False(t, pre.IsTesting())
+	assert.False(t, pre.IsProduction())
+	assert.Equal(t, "conf/pre", pre.ConfigDir())
+	assert.Equal(t, gin.ReleaseMode, pre.GinMode())
+
+	prod := Environment("prod-eu")
+	assert.True(t, prod.IsProduction())
+	assert.False(t, prod.IsStaging())
+	assert.Equal(t, "conf/production", prod.ConfigDir())
+	assert.Equal(t, gin.DebugMode, prod.GinMode())
+	assert.Contains(t, Environments(), "pre")
+
+	assert.True(t, EnvTesting.IsTesting())
+	assert.True(t, EnvDevelopment.IsDevelopment())
+	assert.Equal(t, gin.ReleaseMode, EnvStaging.GinMode())
+}
+
+func TestLookupEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "conf", "alice"), os.ModePerm))
+	assert.Nil(t, os.Chdir(dir))
+
+	//an unregistered environment is rejected even with a config directory, and behaves like production
+	for _, env := range []Environment{"alice", "bob", "", "../conf", "alice/x"} {
+		_, ok := LookupEnvironment(env)
+		assert.False(t, ok, env)
+		assert.True(t, env.Invalid(), env)
+		assert.True(t, env.IsProduction(), env)
+		assert.False(t, env.IsDevelopment(), env)
+		assert.Equal(t, gin.ReleaseMode, env.GinMode(), env)
+	}
+	assert.Equal(t, "conf/alice", Environment("alice").ConfigDir())
+
+	previous, set := os.LookupEnv("ENVIRONMENT")
+	defer func() {
+		if set {
+			os.Setenv("ENVIRONMENT", previous)
+		} else {
+			os.Unsetenv("ENVIRONMENT")
+		}
+	}()
+	os.Setenv("ENVIRONMENT", "alice")
+	_, err := NewGlobalEnvironment()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,216 @@
+package etcdclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/utils"
+	"sync"
+	"time"
+)
+
+// the interval of Watch to wait for etcd or to watch again after the watch channel is closed
+const configWatchRetryInterval = 3 * time.Second
+
+// ConfigSource reads the Config document from an etcd key,
+// the document is stored in the same json/yaml/ini format as the local config file
+type ConfigSource struct {
+	Key string
+	// Format of the document, the extension of Key by default
+	Format string
+	// RetryInterval of Watch, 3s by default
+	RetryInterval time.Duration
+	// Dial connects to the etcd of the local config, New by default
+	Dial func(endpoints []string, dialTimeout, reqTimeout int64) (*Client, error)
+
+	mu     sync.Mutex
+	stop   chan struct{}
+	closed bool
+	// local is the config of the last Read, Watch connects to its etcd
+	local *common.Config
+	// client is owned by the source and closed by Close, the default client of Init is left alone
+	client *Client
+}
+
+// NewConfigSource e.g. NewConfigSource("/common/config/production/main.yaml")
+func NewConfigSource(key string) *ConfigSource {
+	return &ConfigSource{
+		Key:    key,
+		Format: utils.Ext(key),
+	}
+}
+
+func (s *ConfigSource) Name() string {
+	return "etcd:" + s.Key
+}
+
+// Read connects to the etcd endpoints of the local config with a client of its own
+func (s *ConfigSource) Read(local *common.Config) ([]byte, string, error) {
+	client, err := s.connect(local)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := client.timeoutContext()
+	defer cancel()
+	start := time.Now()
+	resp, err := client.Get(ctx, s.Key)
+	observeOp("get", start, err)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, "", fmt.Errorf("config key %s not found", s.Key)
+	}
+	return resp.Kvs[0].Value, s.Format, nil
+}
+
+// connect returns the client of the source, it connects to the etcd of local, or of the last Read when local is nil
+func (s *ConfigSource) connect(local *common.Config) (*Client, error) {
+	s.mu.Lock()
+	if local != nil {
+		s.local = local
+	}
+	client, closed, local := s.client, s.closed, s.local
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrConfigSourceClosed
+	}
+	if client != nil {
+		return client, nil
+	}
+	if local == nil {
+		return nil, ErrEtcdNotInit
+	}
+	dial := s.Dial
+	if dial == nil {
+		dial = New
+	}
+	client, err := dial(local.Etcd.Endpoints, local.Etcd.DialTimeout, local.Etcd.ReqTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.client != nil {
+		//closed or connected by another call while dialing
+		_ = client.Close()
+		if s.closed {
+			return nil, ErrConfigSourceClosed
+		}
+		return s.client, nil
+	}
+	s.client = client
+	return client, nil
+}
+
+// Watch watches Key in the background, it connects again when etcd was unreachable by Read and watches again
+// when the watch channel is closed, e.g. after a compaction. onChange is also called every time the watch
+// is established again as the updates in between are missed. The watch runs until Close.
+func (s *ConfigSource) Watch(onChange func()) error {
+	go s.watch(onChange)
+	return nil
+}
+
+// Close stops the watch of Watch and closes the client of the source
+func (s *ConfigSource) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stopChan())
+	client := s.client
+	s.client = nil
+	s.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// stopChan must be called with mu held, the ConfigSource may be created without NewConfigSource
+func (s *ConfigSource) stopChan() chan struct{} {
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+	}
+	return s.stop
+}
+
+func (s *ConfigSource) watch(onChange func()) {
+	retry := s.RetryInterval
+	if retry <= 0 {
+		retry = configWatchRetryInterval
+	}
+	s.mu.Lock()
+	stop := s.stopChan()
+	s.mu.Unlock()
+	missed := false
+	for {
+		client, err := s.connect(nil)
+		if err == ErrConfigSourceClosed {
+			return
+		}
+		if err != nil {
+			//the config was read from the local file
+			if !missed {
+				s.logError(fmt.Sprintf("connect to etcd of config key %s failed, retry in %s, error:%s", s.Key, retry, err.Error()))
+			}
+			missed = true
+			select {
+			case <-stop:
+				return
+			case <-time.After(retry):
+			}
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		watchChan := client.Watch(ctx, s.Key)
+		if missed {
+			onChange()
+		}
+		s.watchChanges(stop, watchChan, onChange)
+		cancel()
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		s.logError(fmt.Sprintf("watch of config key %s is closed, watch again in %s", s.Key, retry))
+		missed = true
+		select {
+		case <-stop:
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// watchChanges calls onChange for the updates until the watch channel is closed or fails, or Close is called
+func (s *ConfigSource) watchChanges(stop chan struct{}, watchChan clientv3.WatchChan, onChange func()) {
+	for {
+		select {
+		case <-stop:
+			return
+		case resp, ok := <-watchChan:
+			if !ok {
+				return
+			}
+			if err := resp.Err(); err != nil {
+				s.logError(fmt.Sprintf("watch config key %s failed, error:%s", s.Key, err.Error()))
+				return
+			}
+			if len(resp.Events) > 0 {
+				onChange()
+			}
+		}
+	}
+}
+
+func (s *ConfigSource) logError(msg string) {
+	if l := logger.GetLogger(); l != nil {
+		l.Error(msg)
+	}
+}
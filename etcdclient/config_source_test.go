@@ -0,0 +1,91 @@
+package etcdclient_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/servertest"
+)
+
+func TestConfigSourceWatch(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	previous := etcdclient.Set(nil)
+	defer etcdclient.Set(previous)
+
+	e := servertest.NewEtcd()
+	var mu sync.Mutex
+	up := false
+	source := etcdclient.NewConfigSource("/common/config/testing/main.json")
+	source.RetryInterval = 10 * time.Millisecond
+	source.Dial = func(endpoints []string, dialTimeout, reqTimeout int64) (*etcdclient.Client, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !up {
+			return nil, errors.New("etcd is unreachable")
+		}
+		return etcdclient.NewClient(e.Client(), time.Second), nil
+	}
+	defer source.Close()
+	changes := make(chan struct{}, 10)
+	//etcd is unreachable, the config is read from the local file
+	_, _, err := source.Read(&common.Config{})
+	assert.NotNil(t, err)
+	assert.Nil(t, source.Watch(func() { changes <- struct{}{} }))
+	expectNoChange(t, changes)
+
+	//etcd recovers, the config is read again and then watched
+	ctx := context.Background()
+	_, err = e.Put(ctx, source.Key, `{"system":{"addr":8080}}`)
+	assert.Nil(t, err)
+	mu.Lock()
+	up = true
+	mu.Unlock()
+	expectChange(t, changes)
+	data, _, err := source.Read(&common.Config{})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"system":{"addr":8080}}`, string(data))
+	_, err = e.Put(ctx, source.Key, `{"system":{"addr":8081}}`)
+	assert.Nil(t, err)
+	expectChange(t, changes)
+
+	//the watch channel is closed, e.g. by a compaction, it is watched again
+	e.CancelWatches()
+	expectChange(t, changes)
+	_, err = e.Put(ctx, source.Key, `{"system":{"addr":8082}}`)
+	assert.Nil(t, err)
+	expectChange(t, changes)
+	expectNoChange(t, changes)
+
+	//the client of the source is closed, the default client is left alone
+	//the ctx client of the fake always returns context.Canceled
+	_ = source.Close()
+	_, _, err = source.Read(&common.Config{})
+	assert.Equal(t, etcdclient.ErrConfigSourceClosed, err)
+	assert.Nil(t, etcdclient.Set(nil))
+}
+
+func expectChange(t *testing.T, changes chan struct{}) {
+	t.Helper()
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("onChange is not called")
+	}
+}
+
+func expectNoChange(t *testing.T, changes chan struct{}) {
+	t.Helper()
+	select {
+	case <-changes:
+		t.Fatal("unexpected onChange")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
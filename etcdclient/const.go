@@ -10,6 +10,8 @@ const (
 )
 
 var (
-	ErrValueMayChanged = errors.New("The value has been changed by others on this time.")
-	ErrEtcdNotInit     = errors.New("etcd is not initialized")
+	ErrValueMayChanged    = errors.New("The value has been changed by others on this time.")
+	ErrEtcdNotInit        = errors.New("etcd is not initialized")
+	ErrRegisterStopped    = errors.New("etcd register is stopped")
+	ErrConfigSourceClosed = errors.New("etcd config source is closed")
 )
@@ -4,81 +4,162 @@ import (
 	"context"
 	"fmt"
 	"github.com/coreos/etcd/clientv3"
-	"github.com/tmnhs/common"
 	"github.com/tmnhs/common/logger"
 	"strings"
+	"sync"
 	"time"
 )
 
 //Etcd连接
 var _defaultEtcd *Client
 
+//保护Init/Set/Close对_defaultEtcd的替换，后台goroutine通过current读取
+var _defaultEtcdMu sync.RWMutex
+
+func current() *Client {
+	_defaultEtcdMu.RLock()
+	defer _defaultEtcdMu.RUnlock()
+	return _defaultEtcd
+}
+
+//OpObserver 在每次etcd操作后调用，例如监控
+type OpObserver func(op string, duration time.Duration, err error)
+
+var (
+	_opObserver   OpObserver
+	_opObserverMu sync.RWMutex
+)
+
+//SetOpObserver 设置etcd操作的观察者，Put/Get/Delete等方法完成后调用
+func SetOpObserver(f OpObserver) {
+	_opObserverMu.Lock()
+	defer _opObserverMu.Unlock()
+	_opObserver = f
+}
+
+func observeOp(op string, start time.Time, err error) {
+	_opObserverMu.RLock()
+	f := _opObserver
+	_opObserverMu.RUnlock()
+	if f != nil {
+		f(op, time.Since(start), err)
+	}
+}
+
 type Client struct {
 	*clientv3.Client
 	reqTimeout time.Duration
 }
 
 func Init(endpoints []string, dialTimeout, reqTimeout int64) (*Client, error) {
+	client, err := New(endpoints, dialTimeout, reqTimeout)
+	if err != nil {
+		fmt.Printf("connect to etcd failed, err:%v\n", err)
+		return nil, err
+	}
+	_defaultEtcdMu.Lock()
+	_defaultEtcd = client
+	_defaultEtcdMu.Unlock()
+	return client, nil
+}
+
+func GetEtcd() *Client {
+	client := current()
+	if client == nil {
+		logger.GetLogger().Error("etcd is not initialized")
+		return nil
+	}
+	return client
+}
+
+//New 连接etcd，和Init不同的是不替换默认client，由调用者关闭
+func New(endpoints []string, dialTimeout, reqTimeout int64) (*Client, error) {
 	cli, err := clientv3.New(clientv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: time.Duration(dialTimeout) * time.Second,
 	})
 	if err != nil {
-		fmt.Printf("connect to etcd failed, err:%v\n", err)
 		return nil, err
 	}
-	_defaultEtcd = &Client{
+	return &Client{
 		Client:     cli,
 		reqTimeout: time.Duration(reqTimeout) * time.Second,
-	}
-	return _defaultEtcd, nil
+	}, nil
 }
 
-func GetEtcd() *Client {
-	if _defaultEtcd == nil {
-		logger.GetLogger().Error("etcd is not initialized")
+//NewClient 使用已经创建的clientv3.Client，例如测试中clientv3.NewCtxClient创建的假etcd
+func NewClient(cli *clientv3.Client, reqTimeout time.Duration) *Client {
+	return &Client{Client: cli, reqTimeout: reqTimeout}
+}
+
+//Set 替换默认client并返回之前的client
+func Set(client *Client) (previous *Client) {
+	_defaultEtcdMu.Lock()
+	defer _defaultEtcdMu.Unlock()
+	previous = _defaultEtcd
+	_defaultEtcd = client
+	return previous
+}
+
+//Close 关闭etcd连接
+func Close() error {
+	_defaultEtcdMu.Lock()
+	client := _defaultEtcd
+	_defaultEtcd = nil
+	_defaultEtcdMu.Unlock()
+	if client == nil {
 		return nil
 	}
-	return _defaultEtcd
+	return client.Close()
 }
 
 func Put(key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
-	ctx, cancel := NewEtcdTimeoutContext()
+	return client.put(key, val, opts...)
+}
+
+func (c *Client) put(key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	ctx, cancel := c.timeoutContext()
 	defer cancel()
-	return _defaultEtcd.Put(ctx, key, val, opts...)
+	start := time.Now()
+	resp, err := c.Put(ctx, key, val, opts...)
+	observeOp("put", start, err)
+	return resp, err
 }
 
 func PutWithTtl(key, val string, ttl int64) (*clientv3.PutResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
-	ctx, cancel := NewEtcdTimeoutContext()
-	defer cancel()
 	//申请一个lease(租约)
-	leaseRsp, err := Grant(ttl)
+	leaseRsp, err := client.grant(ttl)
 	if err != nil {
 		return nil, err
 	}
-	return _defaultEtcd.Put(ctx, key, val, clientv3.WithLease(leaseRsp.ID))
+	return client.put(key, val, clientv3.WithLease(leaseRsp.ID))
 }
 
 func PutWithModRev(key, val string, rev int64) (*clientv3.PutResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
 	if rev == 0 {
-		return Put(key, val)
+		return client.put(key, val)
 	}
 
-	ctx, cancel := NewEtcdTimeoutContext()
-	tresp, err := _defaultEtcd.Txn(ctx).
+	ctx, cancel := client.timeoutContext()
+	start := time.Now()
+	tresp, err := client.Txn(ctx).
 		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
 		Then(clientv3.OpPut(key, val)).
 		Commit()
 	cancel()
+	observeOp("txn", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -92,56 +173,99 @@ func PutWithModRev(key, val string, rev int64) (*clientv3.PutResponse, error) {
 }
 
 func Get(key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
-	ctx, cancel := NewEtcdTimeoutContext()
+	ctx, cancel := client.timeoutContext()
 	defer cancel()
-	return _defaultEtcd.Get(ctx, key, opts...)
+	start := time.Now()
+	resp, err := client.Get(ctx, key, opts...)
+	observeOp("get", start, err)
+	return resp, err
+}
+
+//Ping 检查etcd连接，用于健康检查，和etcd自己的健康检查一样读取health这个key
+func Ping(ctx context.Context) error {
+	client := current()
+	if client == nil {
+		return ErrEtcdNotInit
+	}
+	start := time.Now()
+	_, err := client.Get(ctx, "health")
+	observeOp("get", start, err)
+	return err
 }
 
 func Delete(key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
-	ctx, cancel := NewEtcdTimeoutContext()
+	ctx, cancel := client.timeoutContext()
 	defer cancel()
-	return _defaultEtcd.Delete(ctx, key, opts...)
+	start := time.Now()
+	resp, err := client.Delete(ctx, key, opts...)
+	observeOp("delete", start, err)
+	return resp, err
 }
 
+//Watch 未初始化时返回已经关闭的channel
 func Watch(key string, opts ...clientv3.OpOption) clientv3.WatchChan {
-	return _defaultEtcd.Watch(context.Background(), key, opts...)
+	client := current()
+	if client == nil {
+		logger.GetLogger().Error(fmt.Sprintf("etcd watch %s failed, error:%s", key, ErrEtcdNotInit.Error()))
+		closed := make(chan clientv3.WatchResponse)
+		close(closed)
+		return closed
+	}
+	return client.Watch(context.Background(), key, opts...)
 }
 
 func Grant(ttl int64) (*clientv3.LeaseGrantResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
-	ctx, cancel := NewEtcdTimeoutContext()
+	return client.grant(ttl)
+}
+
+func (c *Client) grant(ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	ctx, cancel := c.timeoutContext()
 	defer cancel()
-	return _defaultEtcd.Grant(ctx, ttl)
+	start := time.Now()
+	resp, err := c.Grant(ctx, ttl)
+	observeOp("grant", start, err)
+	return resp, err
 }
 
 func Revoke(id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return nil, ErrEtcdNotInit
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), _defaultEtcd.reqTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), client.reqTimeout)
 	defer cancel()
-	return _defaultEtcd.Revoke(ctx, id)
+	start := time.Now()
+	resp, err := client.Revoke(ctx, id)
+	observeOp("revoke", start, err)
+	return resp, err
 }
 
 func GetLock(key string, id clientv3.LeaseID) (bool, error) {
-	if _defaultEtcd == nil {
+	client := current()
+	if client == nil {
 		return false, ErrEtcdNotInit
 	}
 	key = fmt.Sprintf(KeyEtcdLock, key)
-	ctx, cancel := NewEtcdTimeoutContext()
-	resp, err := _defaultEtcd.Txn(ctx).
+	ctx, cancel := client.timeoutContext()
+	start := time.Now()
+	resp, err := client.Txn(ctx).
 		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
 		Then(clientv3.OpPut(key, "", clientv3.WithLease(id))).
 		Commit()
 	cancel()
+	observeOp("txn", start, err)
 
 	if err != nil {
 		return false, err
@@ -174,11 +298,20 @@ func (c *etcdTimeoutContext) Err() error {
 	return err
 }
 
-// NewEtcdTimeoutContext return a new etcdTimeoutContext
+// NewEtcdTimeoutContext return a new etcdTimeoutContext of the default client,
+// a context without timeout when etcd is not initialized
 func NewEtcdTimeoutContext() (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithTimeout(context.Background(), _defaultEtcd.reqTimeout)
+	client := current()
+	if client == nil {
+		return context.WithCancel(context.Background())
+	}
+	return client.timeoutContext()
+}
+
+func (c *Client) timeoutContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.reqTimeout)
 	etcdCtx := &etcdTimeoutContext{}
 	etcdCtx.Context = ctx
-	etcdCtx.etcdEndpoints = common.GetConfigModels().Etcd.Endpoints
+	etcdCtx.etcdEndpoints = c.Endpoints()
 	return etcdCtx, cancel
 }
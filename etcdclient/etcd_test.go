@@ -0,0 +1,44 @@
+package etcdclient_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/servertest"
+)
+
+func TestHelpersWithoutClient(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	previous := etcdclient.Set(nil)
+	defer etcdclient.Set(previous)
+
+	_, err := etcdclient.PutWithTtl("/a", "1", 10)
+	assert.Equal(t, etcdclient.ErrEtcdNotInit, err)
+	_, err = etcdclient.GetLock("a", 1)
+	assert.Equal(t, etcdclient.ErrEtcdNotInit, err)
+	//the watch channel is closed
+	_, ok := <-etcdclient.Watch("/a")
+	assert.False(t, ok)
+	ctx, cancel := etcdclient.NewEtcdTimeoutContext()
+	cancel()
+	assert.NotNil(t, ctx.Err())
+}
+
+func TestPutWithTtl(t *testing.T) {
+	servertest.FakeEtcd(t)
+	_, err := etcdclient.PutWithTtl("/a", "1", 10)
+	assert.Nil(t, err)
+	resp, err := etcdclient.Get("/a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Kvs))
+	assert.NotZero(t, resp.Kvs[0].Lease)
+	ok, err := etcdclient.GetLock("a", 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = etcdclient.GetLock("a", 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -2,43 +2,118 @@ package etcdclient
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/coreos/etcd/clientv3"
 	"github.com/tmnhs/common/logger"
+	"sync"
 	"time"
 )
 
+//租约丢失或者注册失败后重新注册的默认间隔
+const registerRetryInterval = 3 * time.Second
+
 //etcd服务注册并租约
 type ServerReg struct {
-	Client        *Client
-	stop          chan error
+	Client   *Client
+	stop     chan struct{}
+	stopOnce sync.Once
+	//registering is held by the registration in progress, Deregister waits for it
+	registering   chan struct{}
+	mu            sync.Mutex
 	leaseId       clientv3.LeaseID
 	cancelFunc    func()
 	keepAliveChan <-chan *clientv3.LeaseKeepAliveResponse
+	key, value    string
 	//time-to-live
 	Ttl int64
+	//RetryInterval 租约丢失或者注册失败后重新注册的间隔，默认3s
+	RetryInterval time.Duration
+}
+
+//ServiceInstance 注册到etcd的服务实例信息
+type ServiceInstance struct {
+	Name      string    `json:"name"`
+	Addr      string    `json:"addr"`
+	Version   string    `json:"version"`
+	Env       string    `json:"env"`
+	Weight    int       `json:"weight"`
+	Zone      string    `json:"zone,omitempty"`
+	StartTime time.Time `json:"start_time"`
+}
+
+//Key 服务实例的key，prefix/name/addr
+func (i *ServiceInstance) Key(prefix string) string {
+	return fmt.Sprintf("%s%s/%s", prefix, i.Name, i.Addr)
+}
+
+func (i *ServiceInstance) Value() (string, error) {
+	b, err := json.Marshal(i)
+	return string(b), err
 }
 
+//NewServerReg 使用当前的默认client(Init或Set设置)，之后替换默认client不影响返回的ServerReg
 func NewServerReg(ttl int64) *ServerReg {
 	return &ServerReg{
-		Client: _defaultEtcd,
-		Ttl:    ttl,
-		stop:   make(chan error),
+		Client:      current(),
+		Ttl:         ttl,
+		stop:        make(chan struct{}),
+		registering: make(chan struct{}, 1),
 	}
 }
 
 func (s *ServerReg) Register(key string, value string) error {
-	if err := s.setLease(s.Ttl); err != nil {
+	s.mu.Lock()
+	s.key, s.value = key, value
+	s.mu.Unlock()
+	if err := s.register(); err != nil {
 		return err
 	}
 	go s.keepAlive()
-	if err := s.putService(key, value); err != nil {
+	return nil
+}
+
+//KeepRegistered 在后台注册，注册失败或者租约丢失(例如etcd不可用超过ttl)后重新注册，直到Deregister
+func (s *ServerReg) KeepRegistered(key string, value string) {
+	s.mu.Lock()
+	s.key, s.value = key, value
+	s.mu.Unlock()
+	go func() {
+		if err := s.register(); err != nil {
+			logger.GetLogger().Error(fmt.Sprintf("etcd register %s failed, retry later, error:%s", key, err.Error()))
+			if !s.retry() {
+				return
+			}
+		}
+		s.keepAlive()
+	}()
+}
+
+func (s *ServerReg) register() error {
+	if s.Client == nil {
+		return ErrEtcdNotInit
+	}
+	//the registrations are serialized with Deregister, a registration after Deregister is stopped
+	s.registering <- struct{}{}
+	defer func() { <-s.registering }()
+	if s.stopped() {
+		return ErrRegisterStopped
+	}
+	if err := s.setLease(s.Ttl); err != nil {
 		return err
 	}
-	return nil
+	s.mu.Lock()
+	key, value := s.key, s.value
+	s.mu.Unlock()
+	return s.putService(key, value)
 }
 
 func (s *ServerReg) setLease(ttl int64) error {
-	leaseResp, err := Grant(ttl)
+	grantCtx, grantCancel := context.WithTimeout(context.Background(), s.Client.reqTimeout)
+	start := time.Now()
+	leaseResp, err := s.Client.Grant(grantCtx, ttl)
+	grantCancel()
+	observeOp("grant", start, err)
 	if err != nil {
 		return err
 	}
@@ -47,41 +122,142 @@ func (s *ServerReg) setLease(ttl int64) error {
 	leaseRespChan, err := s.Client.KeepAlive(ctx, leaseResp.ID)
 
 	if err != nil {
+		cancelFunc()
 		return err
 	}
+	s.mu.Lock()
+	if s.stopped() {
+		//Deregister was called during the grant, the new lease is not kept
+		s.mu.Unlock()
+		cancelFunc()
+		revokeCtx, revokeCancel := context.WithTimeout(context.Background(), s.Client.reqTimeout)
+		defer revokeCancel()
+		_, err := s.Client.Revoke(revokeCtx, leaseResp.ID)
+		observeOp("revoke", start, err)
+		return ErrRegisterStopped
+	}
+	if s.cancelFunc != nil {
+		//the previous lease is lost
+		s.cancelFunc()
+	}
 	s.leaseId = leaseResp.ID
 	s.cancelFunc = cancelFunc
 	s.keepAliveChan = leaseRespChan
+	s.mu.Unlock()
 	return nil
 }
+
+func (s *ServerReg) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *ServerReg) Stop() {
-	s.stop <- nil
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
 }
 
 //Monitor the lease renewal
 func (s *ServerReg) keepAlive() {
 	for {
+		s.mu.Lock()
+		keepAliveChan := s.keepAliveChan
+		s.mu.Unlock()
 		select {
 		case <-s.stop:
 			return
-		case leaseKeepResp := <-s.keepAliveChan:
-			if leaseKeepResp == nil {
+		case leaseKeepResp := <-keepAliveChan:
+			if leaseKeepResp != nil {
+				continue
+			}
+			select {
+			case <-s.stop:
 				logger.GetLogger().Info("the lease renewal function has been turned off\n")
 				return
+			default:
+			}
+			logger.GetLogger().Warn(fmt.Sprintf("etcd lease of %s is lost, register again", s.key))
+			if !s.retry() {
+				return
 			}
 		}
 	}
 }
 
+//retry registers until it succeeds, false when the registration is stopped
+func (s *ServerReg) retry() bool {
+	for {
+		select {
+		case <-s.stop:
+			return false
+		case <-time.After(s.retryInterval()):
+		}
+		if err := s.register(); err != nil {
+			logger.GetLogger().Error(fmt.Sprintf("etcd register %s failed, error:%s", s.key, err.Error()))
+			continue
+		}
+		logger.GetLogger().Info(fmt.Sprintf("etcd register %s success", s.key))
+		return true
+	}
+}
+
+func (s *ServerReg) retryInterval() time.Duration {
+	if s.RetryInterval > 0 {
+		return s.RetryInterval
+	}
+	return registerRetryInterval
+}
+
 func (s *ServerReg) putService(key, val string) error {
-	kv := clientv3.NewKV(s.Client.Client)
-	_, err := kv.Put(context.TODO(), key, val, clientv3.WithLease(s.leaseId))
+	s.mu.Lock()
+	leaseId := s.leaseId
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), s.Client.reqTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := s.Client.Put(ctx, key, val, clientv3.WithLease(leaseId))
+	observeOp("put", start, err)
 	return err
 }
 
 func (s *ServerReg) RevokeLease() error {
-	s.cancelFunc()
+	s.mu.Lock()
+	cancelFunc, leaseId := s.cancelFunc, s.leaseId
+	s.mu.Unlock()
+	if cancelFunc != nil {
+		cancelFunc()
+	}
 	time.Sleep(2 * time.Second)
-	_, err := Revoke(s.leaseId)
+	_, err := Revoke(leaseId)
+	return err
+}
+
+//Deregister 停止续约并撤销租约，key随租约一起删除，会等待正在进行的注册完成(超过ctx时返回ctx.Err())
+func (s *ServerReg) Deregister(ctx context.Context) error {
+	s.Stop()
+	//wait for the registration in progress, the following ones see the stop
+	select {
+	case s.registering <- struct{}{}:
+		defer func() { <-s.registering }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	cancelFunc, leaseId := s.cancelFunc, s.leaseId
+	s.cancelFunc = nil
+	s.mu.Unlock()
+	if cancelFunc == nil {
+		//never registered
+		return nil
+	}
+	cancelFunc()
+	start := time.Now()
+	_, err := s.Client.Revoke(ctx, leaseId)
+	observeOp("revoke", start, err)
 	return err
 }
@@ -0,0 +1,78 @@
+package etcdclient_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/servertest"
+)
+
+func TestServerRegKeepRegistered(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	e := servertest.FakeEtcd(t)
+	const key = "/common/services/api/127.0.0.1:8080"
+
+	reg := etcdclient.NewServerReg(1)
+	reg.RetryInterval = 10 * time.Millisecond
+	reg.KeepRegistered(key, "v1")
+	lease := waitRegistered(t, key, 0)
+
+	//the lease is lost, e.g. etcd was unavailable for longer than the ttl, the key is registered again
+	e.ExpireLease(lease)
+	assert.NotEqual(t, lease, waitRegistered(t, key, lease))
+
+	//the key is deleted with the lease
+	assert.Nil(t, reg.Deregister(context.Background()))
+	resp, err := etcdclient.Get(key)
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Kvs)
+	leases, err := e.Leases(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, leases.Leases)
+}
+
+// waitRegistered waits for key to be put with another lease than previous and returns the lease
+func waitRegistered(t *testing.T, key string, previous clientv3.LeaseID) clientv3.LeaseID {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := etcdclient.Get(key)
+		assert.Nil(t, err)
+		if len(resp.Kvs) == 1 && clientv3.LeaseID(resp.Kvs[0].Lease) != previous {
+			assert.Equal(t, "v1", string(resp.Kvs[0].Value))
+			return clientv3.LeaseID(resp.Kvs[0].Lease)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not registered", key)
+	return 0
+}
+
+func TestServerRegDeregisterDuringGrant(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	e := servertest.FakeEtcd(t)
+	e.SetGrantDelay(200 * time.Millisecond)
+	const key = "/common/services/api/127.0.0.1:8080"
+
+	reg := etcdclient.NewServerReg(1)
+	reg.RetryInterval = 10 * time.Millisecond
+	reg.KeepRegistered(key, "v1")
+	//the grant is in progress, Deregister waits for it and revokes its lease
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, reg.Deregister(context.Background()))
+
+	//the key is not registered later
+	time.Sleep(300 * time.Millisecond)
+	resp, err := etcdclient.Get(key)
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Kvs)
+	leases, err := e.Leases(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, leases.Leases)
+}
@@ -0,0 +1,269 @@
+package health
+
+// named liveness and readiness checks of the dependencies, served as json reports on /livez and /readyz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusDegraded = "degraded" // a non-critical check failed, the instance still takes traffic
+
+	//timeout of a check registered without WithTimeout
+	DefaultTimeout = 3 * time.Second
+)
+
+var ErrNotReady = errors.New("not ready")
+
+// CheckFunc reports the health of a dependency, it must return when ctx is done
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	fn       CheckFunc
+	timeout  time.Duration
+	critical bool
+	liveness bool
+}
+
+type CheckOption func(c *check)
+
+// WithTimeout sets the timeout of the check, a check running longer fails
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+// NonCritical reports a failure of the check as degraded, the readiness stays true
+func NonCritical() CheckOption {
+	return func(c *check) {
+		c.critical = false
+	}
+}
+
+// Liveness also runs the check on /livez, a failure there gets the process restarted,
+// so it is meant for checks of the process itself, not of the dependencies
+func Liveness() CheckOption {
+	return func(c *check) {
+		c.liveness = true
+	}
+}
+
+// CheckResult is the result of one check in a Report
+type CheckResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+	Latency  float64 `json:"latency_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Report is the json body of /livez and /readyz
+type Report struct {
+	Status  string        `json:"status"`
+	Message string        `json:"message,omitempty"`
+	Time    time.Time     `json:"time"`
+	Checks  []CheckResult `json:"checks"`
+}
+
+// Healthy reports whether the instance should take traffic, a degraded report is healthy
+func (r *Report) Healthy() bool {
+	return r.Status != StatusDown
+}
+
+// Registry holds the checks and the readiness of the instance, it is safe for concurrent use
+type Registry struct {
+	mu     sync.RWMutex
+	checks []*check
+	//readiness set by the server, false before it listens and during the shutdown
+	ready int32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check, a check of the same name is replaced
+func (r *Registry) Register(name string, fn CheckFunc, opts ...CheckOption) {
+	c := &check{name: name, fn: fn, timeout: DefaultTimeout, critical: true}
+	for _, opt := range opts {
+		opt(c)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, old := range r.checks {
+		if old.name == name {
+			r.checks[i] = c
+			return
+		}
+	}
+	r.checks = append(r.checks, c)
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.checks {
+		if c.name == name {
+			r.checks = append(r.checks[:i], r.checks[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetReady switches the readiness, e.g. to false at the beginning of the shutdown so that the load balancers drain the traffic
+func (r *Registry) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&r.ready, v)
+}
+
+func (r *Registry) Ready() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+// Liveness runs the checks registered with Liveness
+func (r *Registry) Liveness(ctx context.Context) *Report {
+	return r.run(ctx, true)
+}
+
+// Readiness runs every check, the report is down when the instance is not ready or a critical check failed
+func (r *Registry) Readiness(ctx context.Context) *Report {
+	if !r.Ready() {
+		return &Report{Status: StatusDown, Message: ErrNotReady.Error(), Time: time.Now(), Checks: []CheckResult{}}
+	}
+	return r.run(ctx, false)
+}
+
+// LivezHandler serves Liveness, the status code is 503 when the report is down
+func (r *Registry) LivezHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Liveness(req.Context()))
+	})
+}
+
+// ReadyzHandler serves Readiness, the status code is 503 when the report is down
+func (r *Registry) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Readiness(req.Context()))
+	})
+}
+
+// run runs the checks concurrently, each with its own timeout
+func (r *Registry) run(ctx context.Context, livenessOnly bool) *Report {
+	r.mu.RLock()
+	checks := make([]*check, 0, len(r.checks))
+	for _, c := range r.checks {
+		if !livenessOnly || c.liveness {
+			checks = append(checks, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	report := &Report{Status: StatusUp, Time: time.Now(), Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == StatusUp {
+			continue
+		}
+		if result.Critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusUp {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, c *check) (result CheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	result = CheckResult{Name: c.name, Status: StatusUp, Critical: c.critical}
+	defer func() {
+		result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- errors.New("panic in check")
+			}
+		}()
+		errCh <- c.fn(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		//the check ignores ctx, its result is dropped
+		err = ctx.Err()
+	}
+	if err != nil {
+		result.Status, result.Error = StatusDown, err.Error()
+	}
+	return result
+}
+
+func writeReport(w http.ResponseWriter, report *Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if !report.Healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+var _defaultRegistry = NewRegistry()
+
+// GetRegistry returns the registry used by the server, WithMysql, WithRedis and WithEtcd register their checks in it
+func GetRegistry() *Registry {
+	return _defaultRegistry
+}
+
+func Register(name string, fn CheckFunc, opts ...CheckOption) {
+	_defaultRegistry.Register(name, fn, opts...)
+}
+
+func Unregister(name string) {
+	_defaultRegistry.Unregister(name)
+}
+
+func SetReady(ready bool) {
+	_defaultRegistry.SetReady(ready)
+}
+
+func Ready() bool {
+	return _defaultRegistry.Ready()
+}
+
+func LivezHandler() http.Handler {
+	return _defaultRegistry.LivezHandler()
+}
+
+func ReadyzHandler() http.Handler {
+	return _defaultRegistry.ReadyzHandler()
+}
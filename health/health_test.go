@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	r := NewRegistry()
+	r.Register("mysql", func(ctx context.Context) error { return nil })
+	r.Register("cache", func(ctx context.Context) error { return errors.New("connection refused") }, NonCritical())
+
+	//not ready before the server listens
+	w := httptest.NewRecorder()
+	r.ReadyzHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	r.SetReady(true)
+	w = httptest.NewRecorder()
+	r.ReadyzHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report Report
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Len(t, report.Checks, 2)
+	assert.Equal(t, StatusUp, report.Checks[0].Status)
+	assert.Equal(t, "connection refused", report.Checks[1].Error)
+
+	//a critical check that times out
+	r.Register("etcd", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, WithTimeout(20*time.Millisecond))
+	w = httptest.NewRecorder()
+	r.ReadyzHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), context.DeadlineExceeded.Error())
+
+	//the liveness does not depend on the dependencies
+	w = httptest.NewRecorder()
+	r.LivezHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	r.Unregister("etcd")
+	r.SetReady(false)
+	report = *r.Readiness(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, ErrNotReady.Error(), report.Message)
+}
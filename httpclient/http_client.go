@@ -4,19 +4,28 @@ package httpclient
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
+
+	"github.com/tmnhs/common/tracing"
 )
 
 //Get方法
 func Get(url string, timeout int64) (result string, err error) {
+	return GetWithContext(context.Background(), url, timeout)
+}
+
+//GetWithContext Get方法，转发ctx中的request id和traceparent
+func GetWithContext(ctx context.Context, url string, timeout int64) (result string, err error) {
 	var client = &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return
 	}
+	tracing.Inject(ctx, req.Header)
 	if timeout > 0 {
 		client.Timeout = time.Duration(timeout) * time.Second
 	}
@@ -39,13 +48,19 @@ func Get(url string, timeout int64) (result string, err error) {
 
 //PostParams
 func PostParams(url string, params string, timeout int64) (result string, err error) {
+	return PostParamsWithContext(context.Background(), url, params, timeout)
+}
+
+//PostParamsWithContext PostParams，转发ctx中的request id和traceparent
+func PostParamsWithContext(ctx context.Context, url string, params string, timeout int64) (result string, err error) {
 	var client = &http.Client{}
 	buf := bytes.NewBufferString(params)
-	req, err := http.NewRequest("POST", url, buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, buf)
 	if err != nil {
 		return
 	}
 	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	tracing.Inject(ctx, req.Header)
 	if timeout > 0 {
 		client.Timeout = time.Duration(timeout) * time.Second
 	}
@@ -68,13 +83,19 @@ func PostParams(url string, params string, timeout int64) (result string, err er
 
 //PostJson
 func PostJson(url string, body string, timeout int64) (result string, err error) {
+	return PostJsonWithContext(context.Background(), url, body, timeout)
+}
+
+//PostJsonWithContext PostJson，转发ctx中的request id和traceparent
+func PostJsonWithContext(ctx context.Context, url string, body string, timeout int64) (result string, err error) {
 	var client = &http.Client{}
 	buf := bytes.NewBufferString(body)
-	req, err := http.NewRequest("POST", url, buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, buf)
 	if err != nil {
 		return
 	}
 	req.Header.Set("Content-type", "application/json")
+	tracing.Inject(ctx, req.Header)
 	if timeout > 0 {
 		client.Timeout = time.Duration(timeout) * time.Second
 	}
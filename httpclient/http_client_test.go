@@ -1,8 +1,13 @@
 package httpclient
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/tmnhs/common/tracing"
 )
 
 // http-client
@@ -16,3 +21,19 @@ func TestPostJson(t *testing.T) {
 	}
 	t.Log(result)
 }
+
+func TestPostJsonWithContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get(tracing.HeaderRequestID) + " " + r.Header.Get(tracing.HeaderTraceparent)))
+	}))
+	defer ts.Close()
+
+	trace := tracing.FromHeader(http.Header{})
+	result, err := PostJsonWithContext(tracing.NewContext(context.Background(), trace), ts.URL, `{"name":"test"}`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != trace.RequestID+" "+trace.Traceparent() {
+		t.Errorf("trace headers are not forwarded, got %q", result)
+	}
+}
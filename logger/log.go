@@ -1,8 +1,10 @@
 package logger
 
 import (
+	"context"
 	"fmt"
 	"github.com/natefinch/lumberjack"
+	"github.com/tmnhs/common/tracing"
 	"github.com/tmnhs/common/utils"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -123,6 +125,26 @@ func Sync() error {
 func Shutdown() {
 	_defaultLogger.Sync()
 }
+//Set 替换默认日志处理器并返回之前的处理器，例如测试中使用zaptest/observer
+func Set(logger *zap.Logger) (previous *zap.Logger) {
+	previous = _defaultLogger
+	_defaultLogger = logger
+	return previous
+}
+
 func GetLogger() *zap.Logger {
 	return _defaultLogger
 }
+
+//WithContext 返回带有请求上下文中request_id、trace_id、span_id字段的日志处理器(ctx可以是*gin.Context)
+func WithContext(ctx context.Context) *zap.Logger {
+	t := tracing.FromContext(ctx)
+	if t == nil || _defaultLogger == nil {
+		return _defaultLogger
+	}
+	return _defaultLogger.With(
+		zap.String("request_id", t.RequestID),
+		zap.String("trace_id", t.TraceID),
+		zap.String("span_id", t.SpanID),
+	)
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+type redisStartKey struct{}
+
+// redisHook observes the latency of every redis command, including the redisclient helpers
+type redisHook struct{}
+
+func (redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisDuration.WithLabelValues(cmd.Name(), redisStatus(cmd.Err())).Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
+
+func (redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		var err error
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && err == nil {
+				err = cmdErr
+			}
+		}
+		redisDuration.WithLabelValues("pipeline", redisStatus(err)).Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
+
+// redisStatus does not count a missing key as an error
+func redisStatus(err error) string {
+	if errors.Is(err, redis.Nil) {
+		return statusOk
+	}
+	return status(err)
+}
+
+const gormStartKey = "metrics:start"
+
+// gormPlugin observes the duration of the gorm statements
+type gormPlugin struct{}
+
+func (gormPlugin) Name() string {
+	return "common:metrics"
+}
+
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", db.Callback().Create().Before("gorm:create").Register, db.Callback().Create().After("gorm:create").Register},
+		{"query", db.Callback().Query().Before("gorm:query").Register, db.Callback().Query().After("gorm:query").Register},
+		{"update", db.Callback().Update().Before("gorm:update").Register, db.Callback().Update().After("gorm:update").Register},
+		{"delete", db.Callback().Delete().Before("gorm:delete").Register, db.Callback().Delete().After("gorm:delete").Register},
+		{"row", db.Callback().Row().Before("gorm:row").Register, db.Callback().Row().After("gorm:row").Register},
+		{"raw", db.Callback().Raw().Before("gorm:raw").Register, db.Callback().Raw().After("gorm:raw").Register},
+	}
+	for _, cb := range callbacks {
+		operation := cb.operation
+		if err := cb.before("metrics:before_"+operation, func(db *gorm.DB) {
+			db.InstanceSet(gormStartKey, time.Now())
+		}); err != nil {
+			return err
+		}
+		if err := cb.after("metrics:after_"+operation, func(db *gorm.DB) {
+			value, ok := db.InstanceGet(gormStartKey)
+			if !ok {
+				return
+			}
+			start, _ := value.(time.Time)
+			err := db.Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = nil
+			}
+			dbDuration.WithLabelValues(operation, db.Statement.Table, status(err)).Observe(time.Since(start).Seconds())
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package metrics
+
+// prometheus metrics of the http server, the clients(redis, mysql, etcd), notify and the go runtime
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tmnhs/common/dbclient"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/notify"
+	"github.com/tmnhs/common/redisclient"
+)
+
+const (
+	statusOk    = "ok"
+	statusError = "error"
+	//route label of the requests that match no route, keeps the cardinality bounded
+	unmatchedRoute = "unmatched"
+)
+
+var (
+	// Registry holds every metric of this package, applications may register their own collectors
+	Registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of http requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of the http requests by method and route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of http requests being served.",
+	})
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Latency of the redis commands by command and status.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "status"})
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Latency of the gorm queries by operation, table and status.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "table", "status"})
+	etcdDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "etcd_op_duration_seconds",
+		Help:    "Latency of the etcdclient operations by operation and status.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"op", "status"})
+	notifyQueue = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "notify_queue_depth",
+		Help: "Number of notify messages waiting to be sent.",
+	}, func() float64 { return float64(notify.QueueLen()) })
+
+	instrumentOnce sync.Once
+	instrumentErr  error
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		redisDuration, dbDuration, etcdDuration, notifyQueue,
+	)
+}
+
+// Handler serves the metrics of Registry in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware counts the requests and observes their latency by route template
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Instrument installs the hooks of redisclient, dbclient and etcdclient,
+// they apply to the clients initialized before and after, it only runs once
+func Instrument() error {
+	instrumentOnce.Do(func() {
+		redisclient.AddHook(redisHook{})
+		etcdclient.SetOpObserver(func(op string, duration time.Duration, err error) {
+			etcdDuration.WithLabelValues(op, status(err)).Observe(duration.Seconds())
+		})
+		instrumentErr = dbclient.Use(gormPlugin{})
+	})
+	return instrumentErr
+}
+
+func status(err error) string {
+	if err != nil {
+		return statusError
+	}
+	return statusOk
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	assert.Nil(t, Instrument())
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware())
+	engine.GET("/user/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	engine.GET("/metrics", gin.WrapH(Handler()))
+	for _, path := range []string{"/user/1", "/user/2", "/missing"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/user/:id",status="200"} 2`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{method="GET",route="/user/:id"`)
+	assert.Contains(t, body, "http_requests_in_flight 1")
+	assert.Contains(t, body, "notify_queue_depth 0")
+	assert.Contains(t, body, "go_goroutines")
+}
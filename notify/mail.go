@@ -24,12 +24,12 @@ type Mail struct {
 func (mail *Mail) SendMsg(msg *Message) {
 	m := gomail.NewMessage()
 
-	m.SetHeader("From", m.FormatAddress(_defaultMail.From, _defaultMail.Nickname)) //这种方式可以添加别名，即“XX官方”
+	m.SetHeader("From", m.FormatAddress(mail.From, mail.Nickname)) //这种方式可以添加别名，即“XX官方”
 	m.SetHeader("To", msg.To...)
 	m.SetHeader("Subject", msg.Subject)
 	m.SetBody("text/html", msg.Body)
 
-	d := gomail.NewDialer(_defaultMail.Host, _defaultMail.Port, _defaultMail.From, _defaultMail.Secret)
+	d := gomail.NewDialer(mail.Host, mail.Port, mail.From, mail.Secret)
 	if err := d.DialAndSend(m); err != nil {
 		logger.GetLogger().Warn(fmt.Sprintf("smtp send msg[%+v] err: %s", msg, err.Error()))
 	}
@@ -1,8 +1,11 @@
 package notify
 
 import (
+	"context"
+	"fmt"
 	"github.com/tmnhs/common/utils"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,9 +21,30 @@ type Message struct {
 	OccurTime string
 }
 
-var msgQueue chan *Message
+// queue 每次Init都创建新的队列，之前的Serve只读取自己的队列，不会读到Init重置的字段
+type queue struct {
+	msgs chan *Message
+	stop chan struct{}
+	//消息从Send到发送完成都算pending，pending变为0时关闭idle，Flush等待idle
+	pending int
+	idle    chan struct{}
+	closed  bool
+	stopped bool
+}
+
+var (
+	//mu保护current及其pending、idle、closed、stopped
+	mu      sync.Mutex
+	current *queue
+	//SetNoticer设置的Noticer，key为Message.Type
+	noticers   = make(map[int]Noticer)
+	noticersMu sync.RWMutex
+	//defaultsMu保护Init设置的_defaultMail和_defaultWebHook
+	defaultsMu sync.RWMutex
+)
 
 func Init(mail *Mail, web *WebHook) {
+	defaultsMu.Lock()
 	_defaultMail = &Mail{
 		Port:     mail.Port,
 		From:     mail.From,
@@ -32,34 +56,183 @@ func Init(mail *Mail, web *WebHook) {
 		Kind: web.Kind,
 		Url:  web.Url,
 	}
-	msgQueue = make(chan *Message, 64)
+	defaultsMu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if current != nil {
+		//之前的Serve发送完已有的消息后退出
+		current.closed = true
+		if current.pending == 0 {
+			current.stopLocked()
+		}
+	}
+	current = &queue{
+		msgs: make(chan *Message, 64),
+		stop: make(chan struct{}),
+	}
 }
 
 func Send(msg *Message) {
-	msgQueue <- msg
+	if msg == nil {
+		return
+	}
+	mu.Lock()
+	q := current
+	if q == nil || q.closed {
+		//not initialized or closed, see Init and Close
+		mu.Unlock()
+		return
+	}
+	if q.pending == 0 {
+		q.idle = make(chan struct{})
+	}
+	q.pending++
+	mu.Unlock()
+	q.msgs <- msg
+}
+
+//Flush 等待已经Send的消息发送完成
+func Flush(ctx context.Context) error {
+	q := currentQueue()
+	if q == nil {
+		return nil
+	}
+	return q.flush(ctx)
+}
+
+//Close 不再接收新的消息，等待已有的消息发送完成后停止Serve
+func Close(ctx context.Context) error {
+	mu.Lock()
+	q := current
+	if q == nil {
+		mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	mu.Unlock()
+	err := q.flush(ctx)
+	mu.Lock()
+	q.stopLocked()
+	mu.Unlock()
+	return err
+}
+
+//SetNoticer 使用n发送msgType类型(1:邮件 2:webhook)的消息并返回之前设置的Noticer，n为nil时恢复Init的邮件或webhook，例如测试中记录消息
+func SetNoticer(msgType int, n Noticer) (previous Noticer) {
+	noticersMu.Lock()
+	defer noticersMu.Unlock()
+	previous = noticers[msgType]
+	if n == nil {
+		delete(noticers, msgType)
+	} else {
+		noticers[msgType] = n
+	}
+	return previous
+}
+
+func getNoticer(msgType int, defaultNoticer Noticer) Noticer {
+	noticersMu.RLock()
+	defer noticersMu.RUnlock()
+	if n, ok := noticers[msgType]; ok {
+		return n
+	}
+	return defaultNoticer
+}
+
+//QueueLen 等待发送的消息数
+func QueueLen() int {
+	q := currentQueue()
+	if q == nil {
+		return 0
+	}
+	return len(q.msgs)
 }
 
 func Serve() {
+	q := currentQueue()
+	if q == nil {
+		return
+	}
 	for {
 		select {
-		case msg := <-msgQueue:
-			if msg == nil {
-
-			}
+		case msg := <-q.msgs:
 			switch msg.Type {
 			case 1:
 				//Mail
 				msg.Check()
-				_defaultMail.SendMsg(msg)
+				getNoticer(msg.Type, defaultMail()).SendMsg(msg)
+				q.done()
 			case 2:
 				//webhook
 				msg.Check()
-				go _defaultWebHook.SendMsg(msg)
+				noticer := getNoticer(msg.Type, defaultWebHook())
+				go func() {
+					defer q.done()
+					noticer.SendMsg(msg)
+				}()
+			default:
+				q.done()
 			}
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+func defaultMail() *Mail {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return _defaultMail
+}
+
+func defaultWebHook() *WebHook {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return _defaultWebHook
+}
+
+func currentQueue() *queue {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+func (q *queue) flush(ctx context.Context) error {
+	mu.Lock()
+	if q.pending == 0 {
+		mu.Unlock()
+		return nil
+	}
+	idle := q.idle
+	mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("notify: %d message(s) not sent: %w", len(q.msgs), ctx.Err())
+	}
+}
+
+//done 消息发送完成，Close或Init之后发送完最后一条消息时停止Serve
+func (q *queue) done() {
+	mu.Lock()
+	defer mu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		close(q.idle)
+		if q.closed {
+			q.stopLocked()
 		}
 	}
 }
 
+func (q *queue) stopLocked() {
+	if !q.stopped {
+		q.stopped = true
+		close(q.stop)
+	}
+}
+
 func (m *Message) Check() {
 	if m.OccurTime == "" {
 		m.OccurTime = time.Now().Format(utils.TimeFormatSecond)
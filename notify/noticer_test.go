@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingNoticer records the messages and waits for release before returning
+type blockingNoticer struct {
+	mu      sync.Mutex
+	release chan struct{}
+	msgs    []string
+}
+
+func (n *blockingNoticer) SendMsg(msg *Message) {
+	<-n.release
+	n.mu.Lock()
+	n.msgs = append(n.msgs, msg.Subject)
+	n.mu.Unlock()
+}
+
+func (n *blockingNoticer) subjects() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.msgs...)
+}
+
+func TestFlushAndInit(t *testing.T) {
+	noticer := &blockingNoticer{release: make(chan struct{})}
+	SetNoticer(2, noticer)
+	defer SetNoticer(2, nil)
+	Init(&Mail{}, &WebHook{})
+	go Serve()
+
+	//the flush gives up while the message is being sent
+	Send(&Message{Type: 2, Subject: "first"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	assert.NotNil(t, Flush(ctx))
+	cancel()
+
+	//a new message is sent while the first one is still pending, then both are flushed
+	Send(&Message{Type: 2, Subject: "second"})
+	close(noticer.release)
+	assert.Nil(t, Flush(context.Background()))
+	assert.ElementsMatch(t, []string{"first", "second"}, noticer.subjects())
+
+	//Init leaves the previous Serve reading its own queue and stops it
+	Init(&Mail{}, &WebHook{})
+	go Serve()
+	Send(&Message{Type: 2, Subject: "third"})
+	assert.Nil(t, Close(context.Background()))
+	assert.ElementsMatch(t, []string{"first", "second", "third"}, noticer.subjects())
+
+	//closed, the message is dropped
+	Send(&Message{Type: 2, Subject: "fourth"})
+	assert.Equal(t, 0, QueueLen())
+}
+
+func TestInitDuringServe(t *testing.T) {
+	noticer := &blockingNoticer{release: make(chan struct{})}
+	close(noticer.release)
+	SetNoticer(2, noticer)
+	defer SetNoticer(2, nil)
+	Init(&Mail{}, &WebHook{Url: "http://127.0.0.1:1/first"})
+
+	//the configs are replaced by Init while the previous Serve is sending
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			Init(&Mail{}, &WebHook{Url: "http://127.0.0.1:1/next"})
+			go Serve()
+			Send(&Message{Type: 2, Subject: "next"})
+		}
+	}()
+	go Serve()
+	Send(&Message{Type: 2, Subject: "first"})
+	wg.Wait()
+	assert.Nil(t, Close(context.Background()))
+	assert.Equal(t, "http://127.0.0.1:1/next", defaultWebHook().Url)
+}
@@ -19,7 +19,7 @@ func (w *WebHook) SendMsg(msg *Message) {
 	if err != nil {
 		return
 	}
-	_, err = httpclient.PostJson(_defaultWebHook.Url, string(b), 0)
+	_, err = httpclient.PostJson(w.Url, string(b), 0)
 	if err != nil {
 		logger.GetLogger().Error(fmt.Sprintf("web hook api send msg[%+v] err: %s", msg, err.Error()))
 	}
@@ -3,3 +3,5 @@ package redisclient
 import "errors"
 
 var ErrRedisNotFound = errors.New("redis not found")
+
+var ErrRedisNotInit = errors.New("redis is not initialized")
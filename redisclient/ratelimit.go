@@ -0,0 +1,67 @@
+package redisclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+//令牌桶限流，桶保存在hash中(tokens:剩余令牌 ts:上次更新时间，毫秒)，使用redis服务器时间，所有实例共享同一个桶
+var tokenBucketScript = redis.NewScript(`
+if redis.replicate_commands then redis.replicate_commands() end
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local retry = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+else
+	retry = math.ceil((requested - tokens) * 1000 / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, retry}
+`)
+
+//TokenBucketScriptHash 令牌桶脚本的sha1(EVALSHA使用)，servertest.Redis据此识别该脚本
+func TokenBucketScriptHash() string {
+	return tokenBucketScript.Hash()
+}
+
+//AllowN 从key对应的令牌桶(每秒rate个令牌，容量burst)中取n个令牌，不足时返回需要等待的时间，
+//共享redis的服务需要在key中加上各自的前缀
+func AllowN(ctx context.Context, key string, rate float64, burst, n int) (allowed bool, retryAfter time.Duration, err error) {
+	client := _defaultRedis
+	if client == nil {
+		return false, 0, ErrRedisNotInit
+	}
+	if rate <= 0 || burst <= 0 {
+		return false, 0, fmt.Errorf("invalid token bucket rate:%v burst:%d", rate, burst)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3000*time.Millisecond)
+	defer cancel()
+
+	result, err := tokenBucketScript.Run(ctx, client, []string{key}, rate, burst, n).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket result:%v", result)
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,42 @@
+package redisclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common/redisclient"
+	"github.com/tmnhs/common/servertest"
+)
+
+func TestAllowN(t *testing.T) {
+	ctx := context.Background()
+	previous := redisclient.Set(nil)
+	_, _, err := redisclient.AllowN(ctx, "bucket", 10, 2, 1)
+	redisclient.Set(previous)
+	assert.Equal(t, redisclient.ErrRedisNotInit, err)
+
+	r := servertest.FakeRedis(t)
+	//the bucket starts full
+	for i := 0; i < 2; i++ {
+		allowed, _, err := redisclient.AllowN(ctx, "bucket", 10, 2, 1)
+		assert.Nil(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter, err := redisclient.AllowN(ctx, "bucket", 10, 2, 1)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+	assert.True(t, retryAfter > 0 && retryAfter <= 100*time.Millisecond, retryAfter)
+	assert.Equal(t, []string{"bucket"}, r.Keys())
+
+	//10 tokens per second
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	allowed, _, err = redisclient.AllowN(ctx, "bucket", 10, 2, 1)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	//more than the burst is never allowed
+	allowed, _, err = redisclient.AllowN(ctx, "other", 10, 2, 3)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+}
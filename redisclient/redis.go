@@ -7,20 +7,32 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/tmnhs/common/logger"
 	"strconv"
+	"sync"
 	"time"
 )
 
 //redis连接
 var _defaultRedis *redis.Client
 
+//添加到每个client的hook，例如监控
+var _hooks []redis.Hook
+
+//保护_hooks以及Init/Set/AddHook对_defaultRedis的替换
+var _hooksMu sync.Mutex
+
 func Init(addr, password string, db int) (r *redis.Client, err error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password, // no password set
 		DB:       db,       // use default DB
 	})
-	_, err = client.Ping(context.Background()).Result()
+	_hooksMu.Lock()
+	for _, hook := range _hooks {
+		client.AddHook(hook)
+	}
 	_defaultRedis = client
+	_hooksMu.Unlock()
+	_, err = client.Ping(context.Background()).Result()
 	return
 }
 
@@ -32,6 +44,30 @@ func GetRedis() *redis.Client {
 	return _defaultRedis
 }
 
+//Set 替换默认client并返回之前的client，例如测试中使用的假redis，AddHook添加的hook也会添加到client
+func Set(client *redis.Client) (previous *redis.Client) {
+	_hooksMu.Lock()
+	defer _hooksMu.Unlock()
+	previous = _defaultRedis
+	if client != nil {
+		for _, hook := range _hooks {
+			client.AddHook(hook)
+		}
+	}
+	_defaultRedis = client
+	return previous
+}
+
+//AddHook 添加redis命令的hook，对已经初始化和之后初始化的client都生效，需要在使用redis之前调用
+func AddHook(hook redis.Hook) {
+	_hooksMu.Lock()
+	defer _hooksMu.Unlock()
+	_hooks = append(_hooks, hook)
+	if _defaultRedis != nil {
+		_defaultRedis.AddHook(hook)
+	}
+}
+
 func GetIntFromRedis(ctx context.Context, key string) (int64, error) {
 	s, err := GetStringFromRedis(ctx, key)
 	if err != nil {
@@ -137,3 +173,21 @@ func GetIntArrayFromRedis(ctx context.Context, key string) ([]int64, error) {
 	// Redis Not Found
 	return a, nil
 }
+
+//Ping 检查redis连接，用于健康检查
+func Ping(ctx context.Context) error {
+	if _defaultRedis == nil {
+		return ErrRedisNotInit
+	}
+	return _defaultRedis.Ping(ctx).Err()
+}
+
+//Close 关闭redis连接
+func Close() error {
+	if _defaultRedis == nil {
+		return nil
+	}
+	client := _defaultRedis
+	_defaultRedis = nil
+	return client.Close()
+}
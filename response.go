@@ -26,6 +26,10 @@ const (
 	ErrorRequestParameter = 1001
 	ErrorTokenGenerate    = 1002
 	ErrorUserNameExist    = 1003
+	ErrorTooManyRequests  = 1004
+	ErrorTokenInvalid     = 1005
+	ErrorTokenExpired     = 1006
+	ErrorPermissionDenied = 1007
 )
 
 func Result(code int, data interface{}, msg string, c *gin.Context) {
@@ -58,6 +62,13 @@ func FailWithMessage(code int, message string, c *gin.Context) {
 func FailWithCode(code int, c *gin.Context) {
 	Result(code, map[string]interface{}{}, "operation failed", c)
 }
+func FailWithStatus(status int, code int, message string, c *gin.Context) {
+	c.AbortWithStatusJSON(status, Response{
+		Code: code,
+		Data: map[string]interface{}{},
+		Msg:  message,
+	})
+}
 func FailWithDetailed(code int, data interface{}, message string, c *gin.Context) {
 	Result(code, data, message, c)
 }
@@ -2,20 +2,29 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jessevdk/go-flags"
 	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/auth"
 	"github.com/tmnhs/common/dbclient"
 	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/health"
 	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/metrics"
 	"github.com/tmnhs/common/notify"
 	"github.com/tmnhs/common/redisclient"
+	"github.com/tmnhs/common/utils"
+	"google.golang.org/grpc"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -38,23 +47,34 @@ const (
 var (
 	ApiOptions struct {
 		flags.Options
-		Environment       string `short:"e" long:"env" description:"Use ApiServer environment" default:"testing"`
-		Version           bool   `short:"v" long:"verbose"  description:"Show ApiServer version"`
-		EnablePProfile    bool   `short:"p" long:"enable-pprof"  description:"enable pprof"`
-		PProfilePort      int    `short:"d" long:"pprof-port"  description:"pprof port" default:"8188"`
-		EnableHealthCheck bool   `short:"a" long:"enable-health-check"  description:"enable health check"`
-		HealthCheckURI    string `short:"i" long:"health-check-uri"  description:"health check uri" default:"/health" `
-		HealthCheckPort   int    `short:"f" long:"health-check-port"  description:"health check port" default:"8186"`
-		ConfigFileName    string `short:"c" long:"config" description:"Use ApiServer config file" default:"main"`
-		EnableDevMode     bool   `short:"m" long:"enable-dev-mode"  description:"enable dev mode"`
+		Environment       string   `short:"e" long:"env" description:"Use ApiServer environment" default:"testing"`
+		Version           bool     `short:"v" long:"verbose"  description:"Show ApiServer version"`
+		EnablePProfile    bool     `short:"p" long:"enable-pprof"  description:"enable pprof"`
+		PProfilePort      int      `short:"d" long:"pprof-port"  description:"pprof port" default:"8188"`
+		EnableHealthCheck bool     `short:"a" long:"enable-health-check"  description:"enable health check"`
+		HealthCheckURI    string   `short:"i" long:"health-check-uri"  description:"health check uri, always answers ok like before, use the livez and readyz uris for the dependency checks" default:"/health" `
+		HealthCheckPort   int      `short:"f" long:"health-check-port"  description:"health check port" default:"8186"`
+		LivezURI          string   `long:"livez-uri" description:"liveness check uri" default:"/livez"`
+		ReadyzURI         string   `long:"readyz-uri" description:"readiness check uri, not ready during the shutdown" default:"/readyz"`
+		EnableMetrics     bool     `long:"enable-metrics" description:"enable prometheus metrics"`
+		MetricsURI        string   `long:"metrics-uri" description:"prometheus metrics uri" default:"/metrics"`
+		MetricsPort       int      `long:"metrics-port" description:"prometheus metrics port, 0 serves the metrics on the api port"`
+		ConfigFileName    string   `short:"c" long:"config" description:"Use ApiServer config file" default:"main"`
+		RemoteConfigKey   string   `short:"r" long:"remote-config" description:"etcd key of the ApiServer config, the local config file is used when etcd is unreachable"`
+		ConfigIncludes    []string `long:"config-include" description:"Extra config file merged over conf/<env>/<config>, can be repeated"`
+		ConfigOverrides   []string `short:"s" long:"set" description:"Override a config value, e.g. --set mysql.password=xxx, takes precedence over files and COMMON_* environment variables"`
+		EnableDevMode     bool     `short:"m" long:"enable-dev-mode"  description:"enable dev mode"`
+		CheckConfig       bool     `long:"check-config" description:"Load and validate the config, print it with secrets masked and exit, the exit status is 0 when valid, 1 when invalid, 2 when it cannot be loaded"`
+		ConfigSchema      bool     `long:"config-schema" description:"Print the JSON Schema of the config, including the registered sections, and exit, the exit status is 1 when struct tags are inconsistent"`
 	}
 )
 
-type Option func(c *common.Config)
+// Option is applied by Builder.Build (and NewApiServer) to the server being built, c is the config of the server
+type Option func(srv *ApiServer, c *common.Config)
 
 //注册mysql服务
 func WithMysql() Option {
-	return func(c *common.Config) {
+	return func(srv *ApiServer, c *common.Config) {
 		mysqlConfig := c.Mysql
 		//db
 		dsn := mysqlConfig.EmptyDsn()
@@ -68,12 +88,13 @@ func WithMysql() Option {
 		} else {
 			logger.GetLogger().Info("api-server:init mysql success")
 		}
+		srv.registerCheck("mysql", dbclient.Ping)
 	}
 }
 
 //注册etcd服务
 func WithEtcd() Option {
-	return func(c *common.Config) {
+	return func(srv *ApiServer, c *common.Config) {
 		etcdConfig := c.Etcd
 		//etcd
 		_, err := etcdclient.Init(etcdConfig.Endpoints, etcdConfig.DialTimeout, etcdConfig.ReqTimeout)
@@ -82,12 +103,13 @@ func WithEtcd() Option {
 		} else {
 			logger.GetLogger().Info("api-server:init etcd success")
 		}
+		srv.registerCheck("etcd", etcdclient.Ping)
 	}
 }
 
 //注册通知服务
 func WithNotify() Option {
-	return func(c *common.Config) {
+	return func(srv *ApiServer, c *common.Config) {
 		//notify
 		notify.Init(&notify.Mail{
 			Port:     c.Notify.Email.Port,
@@ -104,9 +126,58 @@ func WithNotify() Option {
 	}
 }
 
+//通过notify发送配置变更(需要同时使用WithNotify)，msgType为notify.Message的类型(1:邮件 2:webhook)，
+//只有从配置文件加载的配置会变更，Shutdown时取消订阅
+func WithConfigChangeNotify(msgType int, to ...string) Option {
+	return func(srv *ApiServer, c *common.Config) {
+		srv.followConfig(func() func() {
+			send := func(arg interface{}) {
+				diff, ok := arg.(*common.ConfigDiff)
+				if !ok {
+					return
+				}
+				changes := make([]string, 0, len(diff.Changes))
+				for _, change := range diff.Changes {
+					changes = append(changes, change.String())
+				}
+				notify.Send(&notify.Message{
+					Type:      msgType,
+					Subject:   fmt.Sprintf("%s config changed", common.ApiModule),
+					Body:      strings.Join(changes, "; "),
+					To:        to,
+					OccurTime: diff.Time.Format(utils.TimeFormatSecond),
+				})
+			}
+			if err := utils.OnEvent(common.ConfigChangeEvent, send); err != nil {
+				logger.GetLogger().Error(fmt.Sprintf("api-server:subscribe config change notify failed, error:%s", err.Error()))
+				return func() {}
+			}
+			return func() { _ = utils.OffEvent(common.ConfigChangeEvent, send) }
+		})
+	}
+}
+
+//注册jwt认证(auth.JWTAuth()使用)，从配置文件加载的配置中jwt配置变更(例如轮换密钥)后自动更新，开启revocation时需要同时使用WithRedis
+func WithJwt() Option {
+	return func(srv *ApiServer, c *common.Config) {
+		if _, err := auth.Init(c.Jwt); err != nil {
+			logger.GetLogger().Error(fmt.Sprintf("api-server:init jwt failed , error:%s", err.Error()))
+		} else {
+			logger.GetLogger().Info("api-server:init jwt success")
+		}
+		srv.followConfig(func() func() {
+			return common.OnJwtChange(func(old, new common.Jwt) {
+				if _, err := auth.Init(new); err != nil {
+					logger.GetLogger().Error(fmt.Sprintf("api-server:jwt config changed, keep the previous keys, error:%s", err.Error()))
+				}
+			})
+		})
+	}
+}
+
 //注册redis服务
 func WithRedis() Option {
-	return func(c *common.Config) {
+	return func(srv *ApiServer, c *common.Config) {
 		redisConfig := c.Redis
 		//reds
 		_, err := redisclient.Init(redisConfig.Addr, redisConfig.Password, redisConfig.DB)
@@ -115,18 +186,60 @@ func WithRedis() Option {
 		} else {
 			logger.GetLogger().Info("api-server:init redis success")
 		}
+		srv.registerCheck("redis", redisclient.Ping)
 	}
 }
 
-type healthCheckHttpServer struct {
+//注册到etcd(需要在之前使用WithEtcd)，配置为system.registry，服务名system.registry.name必填，开始监听后注册，租约丢失后重新注册，关闭时在drain之前注销
+func WithRegistry() Option {
+	return func(srv *ApiServer, c *common.Config) {
+		registryConfig := c.System.Registry
+		if registryConfig.Name == "" {
+			srv.optionFailed(errors.New("api-server:WithRegistry requires system.registry.name"))
+			return
+		}
+		//the registration keeps the client of WithEtcd, which must be passed before WithRegistry
+		client := etcdclient.GetEtcd()
+		if client == nil {
+			srv.optionFailed(errors.New("api-server:WithRegistry requires WithEtcd before it"))
+			return
+		}
+		srv.onListen(func(addr net.Addr) {
+			instance, err := srv.serviceInstance(c, addr)
+			if err != nil {
+				logger.GetLogger().Error(fmt.Sprintf("api-server:etcd registry failed , error:%s", err.Error()))
+				return
+			}
+			value, err := instance.Value()
+			if err != nil {
+				logger.GetLogger().Error(fmt.Sprintf("api-server:etcd registry failed , error:%s", err.Error()))
+				return
+			}
+			reg := etcdclient.NewServerReg(registryConfig.Ttl)
+			reg.Client = client
+			reg.KeepRegistered(instance.Key(registryConfig.Prefix), value)
+			srv.RegisterShutdownHook(ShutdownHook{Name: "etcd-registry", Phase: PhaseDeregister, Fn: reg.Deregister})
+			logger.GetLogger().Info(fmt.Sprintf("api-server:etcd registry %s", instance.Key(registryConfig.Prefix)))
+		})
+	}
 }
 
-func (server *healthCheckHttpServer) ServeHTTP(response http.ResponseWriter, request *http.Request) {
-	io.WriteString(response, "ok\n")
+//健康检查服务，检查项通过health.Register注册
+func (srv *ApiServer) healthCheckServer() http.Handler {
+	settings, registry := srv.settings, srv.healthRegistry()
+	mux := http.NewServeMux()
+	mux.Handle(settings.livezURI, registry.LivezHandler())
+	mux.Handle(settings.readyzURI, registry.ReadyzHandler())
+	if settings.healthCheckURI != settings.readyzURI && settings.healthCheckURI != settings.livezURI {
+		//the legacy health check is used as a liveness probe, it answers ok while the process is up,
+		//also before ListenAndServe and during the drain
+		mux.HandleFunc(settings.healthCheckURI, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok\n")
+		})
+	}
+	return mux
 }
 
-var healthCheckServer = &healthCheckHttpServer{}
-
 type ApiServer struct {
 	Engine      *gin.Engine
 	HttpServer  *http.Server
@@ -137,6 +250,37 @@ type ApiServer struct {
 	Middlewares []func(*gin.Engine)
 	Shutdowns   []func(*ApiServer)
 	Services    []func(*ApiServer)
+	//hooks of RegisterShutdownHook, see shutdown.go
+	shutdownHooks []ShutdownHook
+	shutdownOnce  sync.Once
+	shutdownErr   error
+	//set by Shutdown before the http server is shut down, see ListenAndServe
+	shuttingDown bool
+	//run once the listener is up, e.g. the etcd registration
+	listenHooks []func(addr net.Addr)
+	env         common.Environment
+	//grpc services served on Addr next to the Engine, see grpc.go
+	grpcServer             *grpc.Server
+	grpcOverHttp           bool
+	grpcServices           []func(*grpc.Server)
+	grpcUnaryInterceptors  []grpc.UnaryServerInterceptor
+	grpcStreamInterceptors []grpc.StreamServerInterceptor
+	grpcOptions            []grpc.ServerOption
+	//*corsPolicy compiled from system.cors
+	corsPolicy atomic.Value
+	//local token buckets of the rate limit middlewares, see ratelimit.go
+	rateLimitOnce  sync.Once
+	rateLimitLocal *localLimiter
+	//set by RateLimitMiddleware, the rules keyed by user are left to it
+	userRateLimitMounted int32
+	//error of the options applied by Builder.Build, see optionFailed
+	optionErr error
+	//cancel the subscriptions of followConfig
+	configCancels []func()
+	//the settings of the Builder, see builder.go
+	settings     serverSettings
+	staticConfig *common.Config
+	health       *health.Registry
 }
 
 //get close Chan
@@ -153,40 +297,30 @@ func (srv *ApiServer) getDoneChanLocked() chan struct{} {
 	return srv.doneChan
 }
 
-func (srv *ApiServer) Shutdown(ctx context.Context) {
-	//Give priority to business shutdown Hook
-	if len(srv.Shutdowns) > 0 {
-		for _, shutdown := range srv.Shutdowns {
-			shutdown(srv)
-		}
-	}
-	//wait for registry shutdown
-	select {
-	case <-time.After(shutdownWait):
-	}
-	// close the HttpServer
-	srv.HttpServer.Shutdown(ctx)
-}
-
 func (srv *ApiServer) setupSignal() {
 	go func() {
 		var sigChan = make(chan os.Signal, 1)
 		signal.Notify(sigChan /*syscall.SIGUSR1,*/, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownMaxAge)
-		defer shutdownCancel()
 
 		for sig := range sigChan {
 			if sig == syscall.SIGINT || sig == syscall.SIGHUP || sig == syscall.SIGTERM {
 				logger.GetLogger().Error(fmt.Sprintf("Graceful shutdown:signal %v to stop api-server ", sig))
-				srv.Shutdown(shutdownCtx)
-			} else {
-				logger.GetLogger().Info(fmt.Sprintf("Caught signal %v", sig))
+				//the logger is synced by the last phase of the shutdown
+				shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownMaxAge)
+				err := srv.Shutdown(shutdownCtx)
+				shutdownCancel()
+				if err != nil {
+					fmt.Println(err.Error())
+				}
+				return
 			}
+			logger.GetLogger().Info(fmt.Sprintf("Caught signal %v", sig))
 		}
-		logger.Shutdown()
 	}()
 }
 
+// NewApiServer parses the command line into ApiOptions and builds the server with NewBuilder,
+// it exits the process for --help, --verbose, --check-config and --config-schema
 func NewApiServer(opts ...Option) (*ApiServer, error) {
 	var parser = flags.NewParser(&ApiOptions, flags.Default)
 	if _, err := parser.Parse(); err != nil {
@@ -202,66 +336,79 @@ func NewApiServer(opts ...Option) (*ApiServer, error) {
 		os.Exit(0)
 	}
 
-	if ApiOptions.EnablePProfile {
-		go func() {
-			fmt.Printf("enable pprof http server at:%d\n", ApiOptions.PProfilePort)
-			fmt.Println(http.ListenAndServe(fmt.Sprintf(":%d", ApiOptions.PProfilePort), nil))
-		}()
+	if ApiOptions.ConfigSchema {
+		os.Exit(printConfigSchema())
 	}
 
-	if ApiOptions.EnableHealthCheck {
-		go func() {
-			fmt.Printf("enable healthcheck http server at:%d\n", ApiOptions.HealthCheckPort)
-			fmt.Println(http.ListenAndServe(fmt.Sprintf(":%d", ApiOptions.HealthCheckPort), healthCheckServer))
-		}()
+	b, err := builderFromFlags(opts)
+	if err != nil {
+		return nil, err
 	}
-	var env = common.Environment(ApiOptions.Environment)
-	if env.Invalid() {
-		var err error
-		env, err = common.NewGlobalEnvironment()
+	if ApiOptions.CheckConfig {
+		env, err := b.environment()
 		if err != nil {
-			return nil, err
+			os.Exit(checkConfig(nil, err))
 		}
+		os.Exit(checkConfig(b.loadConfig(env)))
 	}
+	return b.Build()
+}
 
-	var configFile = ApiOptions.ConfigFileName
-	if configFile == "" {
-		configFile = "main"
-	}
-	defaultConfig, err := common.LoadConfig(env.String(), configFile)
+// checkConfig prints the result of --check-config and returns the exit status
+func checkConfig(c *common.Config, err error) int {
 	if err != nil {
-		fmt.Printf("api-server:init config error:%s", err.Error())
-		return nil, err
-	}
-	logConfig := defaultConfig.Log
-	//log
-	logger.Init(logConfig.Level, logConfig.Format, logConfig.Prefix, logConfig.Director, logConfig.ShowLine, logConfig.EncodeLevel, logConfig.StacktraceKey, logConfig.LogInConsole)
-
-	if len(opts) > 0 {
-		for _, opt := range opts {
-			opt(defaultConfig)
+		fmt.Fprintf(os.Stderr, "api-server:config check failed, error:%s\n", err.Error())
+		var configErr *common.ConfigError
+		if errors.As(err, &configErr) {
+			return 1
 		}
+		return 2
 	}
-	apiServer := &ApiServer{
-		Addr: fmt.Sprintf(":%d", defaultConfig.System.Addr),
+	fmt.Println(common.DumpConfig(c))
+	fmt.Println("config sources:")
+	for _, origin := range c.Origins() {
+		fmt.Printf("  %s <= %s\n", origin.Key, origin.Source)
 	}
+	fmt.Println("api-server:config is ok")
+	return 0
+}
 
-	apiServer.setupSignal()
-	//set gin mode
-	switch env {
-	case common.EnvProduction:
-		gin.SetMode(gin.ReleaseMode)
-	case common.EnvTesting:
-		gin.SetMode(gin.DebugMode)
+// printConfigSchema prints the result of --config-schema and returns the exit status
+func printConfigSchema() int {
+	schema, err := common.GenerateConfigSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "api-server:generate config schema error:%s\n", err.Error())
+		return 2
+	}
+	fmt.Println(string(schema))
+	problems := common.CheckAllConfigTags()
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "api-server:config tag problem:%s\n", problem)
 	}
-	return apiServer, nil
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
 }
 
-// ListenAndServe Listen And Serve()
-func (srv *ApiServer) ListenAndServe() error {
+// BuildEngine creates srv.Engine with the built-in middlewares, then runs the services and adds the middlewares
+// and the routers, ListenAndServe serves it
+func (srv *ApiServer) BuildEngine() *gin.Engine {
+	//the field names and the translations of the errors of the validations, before any request is validated
+	common.RegisterBindValidator()
 	srv.Engine = gin.New()
+	if srv.settings.enableMetrics {
+		srv.Engine.Use(metrics.Middleware())
+		if srv.settings.metricsPort <= 0 {
+			srv.Engine.GET(srv.settings.metricsURI, gin.WrapH(metrics.Handler()))
+		}
+	}
+	srv.Engine.Use(srv.traceMiddleware())
+	srv.Engine.Use(srv.peerIdentityMiddleware())
+	srv.Engine.Use(srv.accessLogMiddleware())
 	srv.Engine.Use(srv.apiRecoveryMiddleware())
 	srv.Engine.Use(srv.cors())
+	srv.Engine.Use(srv.rateLimitMiddleware())
 
 	for _, service := range srv.Services {
 		service(srv)
@@ -270,10 +417,18 @@ func (srv *ApiServer) ListenAndServe() error {
 	for _, middleware := range srv.Middlewares {
 		middleware(srv.Engine)
 	}
+	//the rules keyed by user need the user id set by the middlewares, e.g. auth.JWTAuth
+	srv.Engine.Use(srv.userRateLimitMiddleware())
 
 	for _, c := range srv.Routers {
 		c(srv.Engine)
 	}
+	return srv.Engine
+}
+
+// ListenAndServe Listen And Serve()
+func (srv *ApiServer) ListenAndServe() error {
+	srv.BuildEngine()
 
 	srv.HttpServer = &http.Server{
 		Handler:        srv.Engine,
@@ -282,10 +437,57 @@ func (srv *ApiServer) ListenAndServe() error {
 		WriteTimeout:   30 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
-	if err := srv.HttpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var tlsConfig common.Tls
+	if config := srv.config(); config != nil {
+		tlsConfig = config.System.Tls
+	}
+	if tlsConfig.Enable {
+		reloader, err := newCertReloader(tlsConfig)
+		if err != nil {
+			return err
+		}
+		if srv.HttpServer.TLSConfig, err = newTlsConfig(tlsConfig, reloader); err != nil {
+			return err
+		}
+		if err := reloader.watch(); err != nil {
+			logger.GetLogger().Error(fmt.Sprintf("api-server:watch tls files failed, the certificate is not reloaded, error:%s", err.Error()))
+		}
+		srv.RegisterShutdownHook(ShutdownHook{Name: "tls-watcher", Phase: PhaseCloseClients, Fn: closeFn(reloader.Close)})
+	}
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
 		return err
 	}
-	return nil
+	if srv.grpcServer = srv.newGrpcServer(); srv.grpcServer != nil {
+		listener = srv.serveGrpc(listener, tlsConfig.Enable)
+	}
+	srv.healthRegistry().SetReady(true)
+	srv.mu.Lock()
+	listenHooks := srv.listenHooks
+	srv.mu.Unlock()
+	for _, hook := range listenHooks {
+		hook(listener.Addr())
+	}
+	serve := srv.HttpServer.Serve
+	if tlsConfig.Enable {
+		//the certificate comes from TLSConfig.GetCertificate
+		serve = func(l net.Listener) error { return srv.HttpServer.ServeTLS(l, "", "") }
+	}
+	if err := serve(listener); err != nil && err != http.ErrServerClosed {
+		srv.healthRegistry().SetReady(false)
+		return err
+	}
+	srv.mu.Lock()
+	shuttingDown := srv.shuttingDown
+	srv.mu.Unlock()
+	if !shuttingDown {
+		//HttpServer.Shutdown or Close was called directly, the other phases do not run
+		srv.healthRegistry().SetReady(false)
+		return nil
+	}
+	//wait for the other phases of the shutdown, e.g. closing the clients
+	<-srv.getDoneChan()
+	return srv.shutdownErr
 }
 
 // Register Shutdown Handler
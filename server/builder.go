@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/health"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/metrics"
+)
+
+// serverSettings are the settings of the command line options, NewApiServer fills them from ApiOptions
+type serverSettings struct {
+	addr            string // overrides system.addr when set
+	pprofPort       int    // 0 disables the pprof server
+	healthCheckPort int    // 0 disables the health check server
+	healthCheckURI  string
+	livezURI        string
+	readyzURI       string
+	enableMetrics   bool
+	metricsURI      string
+	metricsPort     int // 0 serves the metrics on the api port
+	handleSignals   bool
+	shareClients    bool
+}
+
+// Builder builds an ApiServer without parsing the command line or exiting the process,
+// e.g. in tests or when the server is part of a larger cli. Every Build returns an independent server,
+// except for the config loaded from the files: it is the global config of the process, see NewBuilder.
+type Builder struct {
+	env        common.Environment
+	configFile string
+	configOpts []common.ConfigOption
+	config     *common.Config
+	health     *health.Registry
+	settings   serverSettings
+	opts       []Option
+}
+
+// NewBuilder returns a builder with the defaults of the command line options, the signals are not handled.
+// Without Config, Build loads the config files with common.LoadConfig: the loaded config replaces the global
+// config (common.GetConfigModels) and its reloads, so the servers of a process built from files share the
+// config of the last Build. The log, cors and jwt subscriptions of such a server are removed by its Shutdown.
+// Use Config for servers with their own config.
+func NewBuilder() *Builder {
+	return &Builder{
+		configFile: "main",
+		settings: serverSettings{
+			healthCheckURI: "/health",
+			livezURI:       "/livez",
+			readyzURI:      "/readyz",
+			metricsURI:     "/metrics",
+		},
+	}
+}
+
+// Env sets the environment, the ENVIRONMENT variable is used when it is not set or invalid
+func (b *Builder) Env(env common.Environment) *Builder {
+	b.env = env
+	return b
+}
+
+// ConfigFile sets the name of the config file in conf/<env>, "main" by default
+func (b *Builder) ConfigFile(name string) *Builder {
+	b.configFile = name
+	return b
+}
+
+// ConfigOptions adds options of common.LoadConfig, e.g. common.WithConfigSource or common.WithConfigOverrides
+func (b *Builder) ConfigOptions(opts ...common.ConfigOption) *Builder {
+	b.configOpts = append(b.configOpts, opts...)
+	return b
+}
+
+// Config uses c instead of loading the config files, the config is not reloaded and the global config
+// (common.GetConfigModels) is left alone, so that the servers of a process can use different configs
+func (b *Builder) Config(c *common.Config) *Builder {
+	b.config = c
+	return b
+}
+
+// Addr overrides system.addr, e.g. "127.0.0.1:0" listens on a random port
+func (b *Builder) Addr(addr string) *Builder {
+	b.settings.addr = addr
+	return b
+}
+
+// PProf serves net/http/pprof on port, 0 disables it
+func (b *Builder) PProf(port int) *Builder {
+	b.settings.pprofPort = port
+	return b
+}
+
+// HealthCheck serves the liveness, the readiness and the legacy health check on port, 0 disables it,
+// the empty uris keep the defaults /livez, /readyz and /health
+func (b *Builder) HealthCheck(port int, livezURI, readyzURI, healthCheckURI string) *Builder {
+	b.settings.healthCheckPort = port
+	if livezURI != "" {
+		b.settings.livezURI = livezURI
+	}
+	if readyzURI != "" {
+		b.settings.readyzURI = readyzURI
+	}
+	if healthCheckURI != "" {
+		b.settings.healthCheckURI = healthCheckURI
+	}
+	return b
+}
+
+// HealthRegistry sets the registry of the server's checks and readiness, health.GetRegistry() by default
+func (b *Builder) HealthRegistry(r *health.Registry) *Builder {
+	b.health = r
+	return b
+}
+
+// Metrics enables the prometheus metrics on uri ("/metrics" when empty), port 0 serves them on the api port
+func (b *Builder) Metrics(uri string, port int) *Builder {
+	b.settings.enableMetrics = true
+	if uri != "" {
+		b.settings.metricsURI = uri
+	}
+	b.settings.metricsPort = port
+	return b
+}
+
+// HandleSignals shuts the server down on SIGINT, SIGHUP and SIGTERM
+func (b *Builder) HandleSignals() *Builder {
+	b.settings.handleSignals = true
+	return b
+}
+
+// ShareClients leaves notify, mysql, redis, etcd and the logger open on Shutdown,
+// for a server sharing them with the other servers of the process
+func (b *Builder) ShareClients() *Builder {
+	b.settings.shareClients = true
+	return b
+}
+
+// Options adds the options applied by Build, e.g. WithMysql()
+func (b *Builder) Options(opts ...Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// environment returns the environment of Env, or the one of the ENVIRONMENT variable
+func (b *Builder) environment() (common.Environment, error) {
+	if !b.env.Invalid() {
+		return b.env, nil
+	}
+	return common.NewGlobalEnvironment()
+}
+
+// loadConfig returns the config of Config, or loads conf/<env>/<config file>
+func (b *Builder) loadConfig(env common.Environment) (*common.Config, error) {
+	if b.config != nil {
+		//a copy per server, the options find their server by the config
+		c := *b.config
+		return &c, nil
+	}
+	return common.LoadConfig(env.String(), b.configFile, b.configOpts...)
+}
+
+// Build loads the config, applies the options and returns the server, it does not listen yet
+func (b *Builder) Build() (*ApiServer, error) {
+	env, err := b.environment()
+	if err != nil {
+		return nil, err
+	}
+	config, err := b.loadConfig(env)
+	if err != nil {
+		fmt.Printf("api-server:init config error:%s", err.Error())
+		return nil, err
+	}
+	loaded := b.config == nil
+	logConfig := config.Log
+	if loaded || logger.GetLogger() == nil {
+		//log
+		logger.Init(logConfig.Level, logConfig.Format, logConfig.Prefix, logConfig.Director, logConfig.ShowLine, logConfig.EncodeLevel, logConfig.StacktraceKey, logConfig.LogInConsole)
+	}
+	logger.GetLogger().Info(fmt.Sprintf("api-server:load config:%s", common.DumpConfig(config)))
+	//cors
+	policy, err := newCorsPolicy(config.System.Cors, env.IsProduction())
+	if err != nil {
+		return nil, err
+	}
+
+	if b.settings.enableMetrics {
+		//the hooks also apply to the clients initialized by the options
+		if err := metrics.Instrument(); err != nil {
+			logger.GetLogger().Error(fmt.Sprintf("api-server:instrument clients failed, error:%s", err.Error()))
+		}
+	}
+	apiServer := &ApiServer{
+		Addr:     fmt.Sprintf(":%d", config.System.Addr),
+		env:      env,
+		settings: b.settings,
+		health:   b.health,
+	}
+	if b.settings.addr != "" {
+		apiServer.Addr = b.settings.addr
+	}
+	if !loaded {
+		apiServer.staticConfig = config
+	}
+	for _, opt := range b.opts {
+		opt(apiServer, config)
+	}
+	if apiServer.optionErr != nil {
+		apiServer.cancelConfigSubscriptions(context.Background())
+		return nil, apiServer.optionErr
+	}
+	apiServer.startSideServers()
+	apiServer.corsPolicy.Store(policy)
+	//re-init the logger when the log section of the config file changes
+	apiServer.followConfig(func() func() {
+		return common.OnLogChange(func(old, new common.Log) {
+			logger.Init(new.Level, new.Format, new.Prefix, new.Director, new.ShowLine, new.EncodeLevel, new.StacktraceKey, new.LogInConsole)
+			logger.GetLogger().Info(fmt.Sprintf("api-server:log config changed, level:%s", new.Level))
+		})
+	})
+	apiServer.followConfig(func() func() {
+		return common.OnSystemChange(func(old, new common.System) {
+			policy, err := newCorsPolicy(new.Cors, env.IsProduction())
+			if err != nil {
+				logger.GetLogger().Error(fmt.Sprintf("api-server:cors config changed, keep the previous policy, error:%s", err.Error()))
+				return
+			}
+			apiServer.corsPolicy.Store(policy)
+		})
+	})
+
+	if b.settings.handleSignals {
+		apiServer.setupSignal()
+	}
+	//set gin mode
+	gin.SetMode(env.GinMode())
+	return apiServer, nil
+}
+
+// builderFromFlags returns the builder of the parsed ApiOptions
+func builderFromFlags(opts []Option) (*Builder, error) {
+	b := NewBuilder().
+		Env(common.Environment(ApiOptions.Environment)).
+		HandleSignals().
+		Options(opts...)
+	if ApiOptions.ConfigFileName != "" {
+		b.ConfigFile(ApiOptions.ConfigFileName)
+	}
+	if ApiOptions.RemoteConfigKey != "" {
+		b.ConfigOptions(common.WithConfigSource(etcdclient.NewConfigSource(ApiOptions.RemoteConfigKey)))
+	}
+	if len(ApiOptions.ConfigIncludes) > 0 {
+		b.ConfigOptions(common.WithConfigIncludes(ApiOptions.ConfigIncludes...))
+	}
+	if len(ApiOptions.ConfigOverrides) > 0 {
+		overrides := make(map[string]string, len(ApiOptions.ConfigOverrides))
+		for _, override := range ApiOptions.ConfigOverrides {
+			kv := strings.SplitN(override, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid config override %q, must be key=value", override)
+			}
+			overrides[kv[0]] = kv[1]
+		}
+		b.ConfigOptions(common.WithConfigOverrides(overrides))
+	}
+	if ApiOptions.EnablePProfile {
+		b.PProf(ApiOptions.PProfilePort)
+	}
+	if ApiOptions.EnableHealthCheck {
+		b.HealthCheck(ApiOptions.HealthCheckPort, ApiOptions.LivezURI, ApiOptions.ReadyzURI, ApiOptions.HealthCheckURI)
+	}
+	if ApiOptions.EnableMetrics {
+		b.Metrics(ApiOptions.MetricsURI, ApiOptions.MetricsPort)
+	}
+	return b, nil
+}
+
+// startSideServers starts the pprof, health check and metrics servers, they are closed after the drain
+func (srv *ApiServer) startSideServers() {
+	settings := srv.settings
+	if settings.pprofPort > 0 {
+		//the handlers of net/http/pprof are on the default mux
+		srv.startSideServer("pprof", settings.pprofPort, nil)
+	}
+	if settings.healthCheckPort > 0 {
+		srv.startSideServer("healthcheck", settings.healthCheckPort, srv.healthCheckServer())
+	}
+	if settings.enableMetrics && settings.metricsPort > 0 {
+		mux := http.NewServeMux()
+		mux.Handle(settings.metricsURI, metrics.Handler())
+		srv.startSideServer("metrics", settings.metricsPort, mux)
+	}
+}
+
+func (srv *ApiServer) startSideServer(name string, port int, handler http.Handler) {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler}
+	go func() {
+		fmt.Printf("enable %s http server at:%d\n", name, port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Println(err)
+		}
+	}()
+	srv.RegisterShutdownHook(ShutdownHook{Name: name + "-server", Phase: PhaseCloseClients, Fn: server.Shutdown})
+}
+
+// config returns the config of Builder.Config, or the global config which follows the reloads
+func (srv *ApiServer) config() *common.Config {
+	if srv.staticConfig != nil {
+		return srv.staticConfig
+	}
+	return common.GetConfigModels()
+}
+
+// followConfig subscribes to the reloads of the global config, e.g. with common.OnJwtChange, nothing is subscribed
+// for the config of Builder.Config which is not reloaded. The subscriptions are cancelled by the shutdown, they
+// would keep the server alive and follow the config of a later Build otherwise.
+func (srv *ApiServer) followConfig(subscribe func() (cancel func())) {
+	if srv.staticConfig != nil {
+		return
+	}
+	cancel := subscribe()
+	srv.mu.Lock()
+	srv.configCancels = append(srv.configCancels, cancel)
+	srv.mu.Unlock()
+}
+
+func (srv *ApiServer) cancelConfigSubscriptions(ctx context.Context) error {
+	srv.mu.Lock()
+	cancels := srv.configCancels
+	srv.configCancels = nil
+	srv.mu.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return nil
+}
+
+// healthRegistry returns the registry of Builder.HealthRegistry, or the default one
+func (srv *ApiServer) healthRegistry() *health.Registry {
+	if srv.health != nil {
+		return srv.health
+	}
+	return health.GetRegistry()
+}
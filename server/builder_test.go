@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/health"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/utils"
+)
+
+func TestBuilderIndependentServers(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	start := func(name string, registry *health.Registry) (*ApiServer, string, chan error) {
+		c := &common.Config{}
+		c.System.Env = name
+		srv, err := NewBuilder().Env(common.Environment("testing")).Config(c).Addr("127.0.0.1:0").
+			HealthRegistry(registry).ShareClients().Build()
+		assert.Nil(t, err)
+		srv.RegisterRouters(func(engine *gin.Engine) {
+			engine.GET("/name", func(c *gin.Context) { c.String(http.StatusOK, srv.config().System.Env) })
+		})
+		addrChan := make(chan net.Addr, 1)
+		srv.onListen(func(addr net.Addr) { addrChan <- addr })
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- srv.ListenAndServe() }()
+		return srv, "http://" + (<-addrChan).String(), serveErr
+	}
+	get := func(url string) string {
+		resp, err := http.Get(url)
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	firstRegistry, secondRegistry := health.NewRegistry(), health.NewRegistry()
+	first, firstURL, firstErr := start("first", firstRegistry)
+	second, secondURL, secondErr := start("second", secondRegistry)
+	assert.Equal(t, "first", get(firstURL+"/name"))
+	assert.Equal(t, "second", get(secondURL+"/name"))
+	assert.True(t, firstRegistry.Ready())
+	assert.True(t, secondRegistry.Ready())
+	assert.Nil(t, common.GetConfigModels())
+
+	//stopping one server leaves the other one serving
+	assert.Nil(t, first.Shutdown(context.Background()))
+	assert.Nil(t, <-firstErr)
+	assert.False(t, firstRegistry.Ready())
+	assert.True(t, secondRegistry.Ready())
+	assert.Equal(t, "second", get(secondURL+"/name"))
+
+	assert.Nil(t, second.Shutdown(context.Background()))
+	assert.Nil(t, <-secondErr)
+}
+
+func TestHealthCheckServer(t *testing.T) {
+	registry := health.NewRegistry()
+	srv, err := NewBuilder().Env(common.Environment("testing")).Config(&common.Config{}).HealthCheck(8186, "", "", "").HealthRegistry(registry).Build()
+	assert.Nil(t, err)
+	handler := srv.healthCheckServer()
+	serve := func(uri string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+		return w
+	}
+
+	//not ready before ListenAndServe, the legacy health check stays a liveness probe
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/readyz").Code)
+	assert.Equal(t, http.StatusOK, serve("/livez").Code)
+	w := serve("/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok\n", w.Body.String())
+
+	registry.SetReady(true)
+	assert.Equal(t, http.StatusOK, serve("/readyz").Code)
+}
+
+func TestFollowConfig(t *testing.T) {
+	subscribed, cancelled := 0, 0
+	subscribe := func() func() {
+		subscribed++
+		return func() { cancelled++ }
+	}
+	//the config of Builder.Config is not reloaded, nothing is subscribed
+	static := &ApiServer{staticConfig: &common.Config{}}
+	static.followConfig(subscribe)
+	assert.Equal(t, 0, subscribed)
+
+	srv := &ApiServer{}
+	srv.followConfig(subscribe)
+	srv.followConfig(subscribe)
+	assert.Equal(t, 2, subscribed)
+	assert.Nil(t, srv.cancelConfigSubscriptions(context.Background()))
+	assert.Equal(t, 2, cancelled)
+	//cancelled once
+	assert.Nil(t, srv.cancelConfigSubscriptions(context.Background()))
+	assert.Equal(t, 2, cancelled)
+}
+
+func TestConfigChangeNotifySubscription(t *testing.T) {
+	srv := &ApiServer{}
+	WithConfigChangeNotify(1, "ops@example.com")(srv, &common.Config{})
+	assert.Equal(t, 1, len(utils.Events[common.ConfigChangeEvent]))
+	//removed by the shutdown
+	assert.Nil(t, srv.cancelConfigSubscriptions(context.Background()))
+	assert.Empty(t, utils.Events[common.ConfigChangeEvent])
+}
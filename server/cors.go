@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tmnhs/common"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const corsRegexPrefix = "regex:"
+
+// corsPolicy is the compiled system.cors config
+type corsPolicy struct {
+	allowAll         bool
+	origins          map[string]bool
+	wildcards        [][2]string //prefix and suffix around the *
+	regexps          []*regexp.Regexp
+	methods          map[string]bool
+	headers          map[string]bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	maxAge           string
+	allowCredentials bool
+}
+
+// newCorsPolicy compiles the cors config, allowing every origin is refused in production
+func newCorsPolicy(c common.Cors, production bool) (*corsPolicy, error) {
+	p := &corsPolicy{
+		allowAll:         c.AllowAll,
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowMethods:     strings.Join(c.AllowMethods, ", "),
+		allowHeaders:     strings.Join(c.AllowHeaders, ", "),
+		exposeHeaders:    strings.Join(c.ExposeHeaders, ", "),
+		allowCredentials: c.AllowCredentials,
+	}
+	if c.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(c.MaxAge.Seconds()))
+	}
+	for _, origin := range c.AllowOrigins {
+		switch {
+		case origin == "*":
+			p.allowAll = true
+		case strings.HasPrefix(origin, corsRegexPrefix):
+			re, err := regexp.Compile(strings.TrimPrefix(origin, corsRegexPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid cors origin %q: %w", origin, err)
+			}
+			p.regexps = append(p.regexps, re)
+		case strings.Contains(origin, "*"):
+			parts := strings.SplitN(strings.ToLower(origin), "*", 2)
+			if strings.Contains(parts[1], "*") || !strings.HasPrefix(parts[1], ".") {
+				return nil, fmt.Errorf("invalid cors origin %q, a wildcard must be a subdomain, e.g. https://*.example.com", origin)
+			}
+			p.wildcards = append(p.wildcards, [2]string{parts[0], parts[1]})
+		default:
+			p.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+	if p.allowAll && production {
+		return nil, fmt.Errorf("cors: allowing every origin is not permitted in production, set system.cors.allow-origins")
+	}
+	for _, method := range c.AllowMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range c.AllowHeaders {
+		p.headers[strings.ToLower(header)] = true
+	}
+	return p, nil
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) &&
+			!strings.Contains(origin[len(w[0]):len(origin)-len(w[1])], "/") {
+			return true
+		}
+	}
+	for _, re := range p.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowPreflight checks the method and the headers requested by a preflight request
+func (p *corsPolicy) allowPreflight(method, headers string) bool {
+	if !p.methods[strings.ToUpper(method)] {
+		return false
+	}
+	for _, header := range strings.Split(headers, ",") {
+		if header = strings.TrimSpace(header); header != "" && !p.headers[strings.ToLower(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// handle sets the cors headers, it returns false when the request has been answered
+func (p *corsPolicy) handle(c *gin.Context) bool {
+	origin := c.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	c.Writer.Header().Add("Vary", "Origin")
+	preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+	if !p.allowOrigin(origin) {
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+		//the browser blocks the response without the cors headers
+		return true
+	}
+
+	if p.allowAll && !p.allowCredentials {
+		c.Header("Access-Control-Allow-Origin", "*")
+	} else {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+	if p.allowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if p.exposeHeaders != "" {
+			c.Header("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		return true
+	}
+
+	if !p.allowPreflight(c.Request.Header.Get("Access-Control-Request-Method"), c.Request.Header.Get("Access-Control-Request-Headers")) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false
+	}
+	c.Header("Access-Control-Allow-Methods", p.allowMethods)
+	if p.allowHeaders != "" {
+		c.Header("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	if p.maxAge != "" {
+		c.Header("Access-Control-Max-Age", p.maxAge)
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+	return false
+}
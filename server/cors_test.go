@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+)
+
+func TestCorsPolicy(t *testing.T) {
+	config := common.Cors{
+		AllowOrigins:     []string{"https://a.com", "https://*.b.com", `regex:^https://c[0-9]+\.com$`},
+		AllowMethods:     []string{"GET", "POST"},
+		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		ExposeHeaders:    []string{"X-Request-Id"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}
+	policy, err := newCorsPolicy(config, true)
+	assert.Nil(t, err)
+	for origin, allowed := range map[string]bool{
+		"https://a.com":           true,
+		"https://x.b.com":         true,
+		"https://b.com":           false,
+		"https://x.b.com.evil":    false,
+		"https://c12.com":         true,
+		"https://evil.com":        false,
+		"http://a.com":            false,
+		"https://evil.com/.b.com": false,
+	} {
+		assert.Equal(t, allowed, policy.allowOrigin(origin), origin)
+	}
+
+	srv := &ApiServer{}
+	srv.corsPolicy.Store(policy)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(srv.cors())
+	engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/ping", nil)
+		req.Header.Set("Origin", origin)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodOptions, "https://a.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type",
+	})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	w = serve(http.MethodOptions, "https://a.com", map[string]string{"Access-Control-Request-Method": "DELETE"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(http.MethodOptions, "https://evil.com", map[string]string{"Access-Control-Request-Method": "GET"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(http.MethodGet, "https://x.b.com", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://x.b.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	w = serve(http.MethodGet, "https://evil.com", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	//permissive mode is refused in production
+	_, err = newCorsPolicy(common.Cors{AllowAll: true}, true)
+	assert.NotNil(t, err)
+	_, err = newCorsPolicy(common.Cors{AllowOrigins: []string{"*"}}, true)
+	assert.NotNil(t, err)
+	policy, err = newCorsPolicy(common.Cors{AllowAll: true}, false)
+	assert.Nil(t, err)
+	assert.True(t, policy.allowOrigin("https://evil.com"))
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/soheilhy/cmux"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/health"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcContentType = "application/grpc"
+	//interval of the status checks of a grpc health watch
+	grpcHealthWatchInterval = 5 * time.Second
+)
+
+// RegisterGrpcService registers grpc services, e.g. func(s *grpc.Server) { pb.RegisterOrderServer(s, &order{}) },
+// they are served on system.addr next to the gin engine
+func (srv *ApiServer) RegisterGrpcService(services ...func(s *grpc.Server)) {
+	srv.grpcServices = append(srv.grpcServices, services...)
+}
+
+// RegisterGrpcUnaryInterceptor adds interceptors after the built-in trace, access log and recovery ones,
+// e.g. auth.UnaryServerInterceptor()
+func (srv *ApiServer) RegisterGrpcUnaryInterceptor(interceptors ...grpc.UnaryServerInterceptor) {
+	srv.grpcUnaryInterceptors = append(srv.grpcUnaryInterceptors, interceptors...)
+}
+
+// RegisterGrpcStreamInterceptor adds stream interceptors after the built-in ones, e.g. auth.StreamServerInterceptor()
+func (srv *ApiServer) RegisterGrpcStreamInterceptor(interceptors ...grpc.StreamServerInterceptor) {
+	srv.grpcStreamInterceptors = append(srv.grpcStreamInterceptors, interceptors...)
+}
+
+// RegisterGrpcServerOption adds options of the grpc server, e.g. grpc.MaxRecvMsgSize, the interceptor options are not supported
+func (srv *ApiServer) RegisterGrpcServerOption(opts ...grpc.ServerOption) {
+	srv.grpcOptions = append(srv.grpcOptions, opts...)
+}
+
+// newGrpcServer returns nil when no grpc service is registered
+func (srv *ApiServer) newGrpcServer() *grpc.Server {
+	if len(srv.grpcServices) == 0 {
+		return nil
+	}
+	unary := append([]grpc.UnaryServerInterceptor{grpcTraceUnary, srv.grpcAccessLogUnary, grpcRecoveryUnary}, srv.grpcUnaryInterceptors...)
+	stream := append([]grpc.StreamServerInterceptor{grpcTraceStream, srv.grpcAccessLogStream, grpcRecoveryStream}, srv.grpcStreamInterceptors...)
+	opts := append([]grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnary(unary)),
+		grpc.StreamInterceptor(chainStream(stream)),
+	}, srv.grpcOptions...)
+	s := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(s, &grpcHealthServer{registry: srv.healthRegistry()})
+	for _, service := range srv.grpcServices {
+		service(s)
+	}
+	return s
+}
+
+// serveGrpc multiplexes grpc and http on the listener and returns the http listener,
+// the grpc requests of tls connections are served by the http server, cmux cannot sniff them
+func (srv *ApiServer) serveGrpc(listener net.Listener, tlsEnable bool) net.Listener {
+	if tlsEnable {
+		srv.grpcOverHttp = true
+		engine := srv.HttpServer.Handler
+		srv.HttpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+				srv.grpcServer.ServeHTTP(w, r)
+				return
+			}
+			engine.ServeHTTP(w, r)
+		})
+		return listener
+	}
+
+	m := cmux.New(listener)
+	grpcListener := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", grpcContentType))
+	httpListener := m.Match(cmux.Any())
+	go func() {
+		if err := srv.grpcServer.Serve(sharedListener{grpcListener}); err != nil && err != cmux.ErrListenerClosed && err != grpc.ErrServerStopped {
+			logger.GetLogger().Error(fmt.Sprintf("api-server:grpc server error:%s", err.Error()))
+		}
+	}()
+	go func() {
+		//returns when the http server closes the listener
+		_ = m.Serve()
+	}()
+	return httpListener
+}
+
+// sharedListener leaves the closing of the shared listener to the http server,
+// the http server would stop with an error when the grpc server closed it first
+type sharedListener struct {
+	net.Listener
+}
+
+func (sharedListener) Close() error {
+	return nil
+}
+
+// stopGrpc waits for the running rpcs, they are cancelled when ctx is done. It must not be used when the rpcs are
+// served by ServeHTTP, GracefulStop panics on their transports, see ApiServer.drain
+func stopGrpc(ctx context.Context, s *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// grpcHealthServer implements the grpc health protocol with the health registry, the empty service is the readiness
+// and the other services are the registered checks, e.g. mysql
+type grpcHealthServer struct {
+	registry *health.Registry
+}
+
+func (h *grpcHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	servingStatus, err := grpcServingStatus(ctx, h.registry, req.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: servingStatus}, nil
+}
+
+func (h *grpcHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		servingStatus, err := grpcServingStatus(stream.Context(), h.registry, req.GetService())
+		if err != nil {
+			servingStatus = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if servingStatus != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: servingStatus}); err != nil {
+				return err
+			}
+			last = servingStatus
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-time.After(grpcHealthWatchInterval):
+		}
+	}
+}
+
+func grpcServingStatus(ctx context.Context, registry *health.Registry, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	report := registry.Readiness(ctx)
+	if service == "" {
+		if report.Healthy() {
+			return healthpb.HealthCheckResponse_SERVING, nil
+		}
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	if !registry.Ready() {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	for _, result := range report.Checks {
+		if result.Name != service {
+			continue
+		}
+		if result.Status == health.StatusUp {
+			return healthpb.HealthCheckResponse_SERVING, nil
+		}
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %s", service)
+}
+
+// grpcTrace is traceMiddleware for grpc, the trace comes from the x-request-id and traceparent metadata
+func grpcTrace(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := make(http.Header)
+	for _, key := range []string{tracing.HeaderRequestID, tracing.HeaderTraceparent, tracing.HeaderTracestate} {
+		if values := md.Get(key); len(values) > 0 {
+			header.Set(key, values[0])
+		}
+	}
+	t := tracing.FromHeader(header)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(tracing.HeaderRequestID, t.RequestID, tracing.HeaderTraceparent, t.Traceparent()))
+	return tracing.NewContext(ctx, t)
+}
+
+func grpcTraceUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(grpcTrace(ctx), req)
+}
+
+func grpcTraceStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: grpcTrace(ss.Context())})
+}
+
+// grpcAccessLog is accessLogMiddleware for grpc, it follows log.access, the routes are the full method names
+func grpcAccessLog(config *common.Config, ctx context.Context, method string, start time.Time, err error) {
+	if config == nil || !config.Log.Access.Enable {
+		return
+	}
+	access := config.Log.Access
+	if matchPath(access.ExcludePaths, method) {
+		return
+	}
+	latency := time.Since(start)
+	code := status.Code(err)
+	slow := access.SlowThreshold > 0 && latency >= access.SlowThreshold
+	failed := code == codes.Internal || code == codes.Unknown || code == codes.Unavailable || code == codes.DataLoss
+	//errors and slow requests are never sampled out
+	if !slow && !failed && access.SampleRate < 1 && matchPath(access.SampleRoutes, method) && rand.Float64() >= access.SampleRate {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("method", "GRPC"),
+		zap.String("route", method),
+		zap.String("code", code.String()),
+		zap.Duration("latency", latency),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("client_ip", peerIP(p.Addr)))
+	}
+	if err != nil {
+		fields = append(fields, zap.String("errors", err.Error()))
+	}
+	log := logger.WithContext(ctx)
+	if log == nil {
+		return
+	}
+	if slow {
+		log.Warn("access:slow request", fields...)
+	} else {
+		log.Info("access", fields...)
+	}
+}
+
+// peerIP returns the ip of the address of a peer like the client_ip of the http access log, the address
+// itself when it has no port, e.g. a unix socket
+func peerIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (srv *ApiServer) grpcAccessLogUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcAccessLog(srv.config(), ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (srv *ApiServer) grpcAccessLogStream(service interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(service, ss)
+	grpcAccessLog(srv.config(), ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// grpcRecover is apiRecoveryMiddleware for grpc, a panic is logged and returned as codes.Internal
+func grpcRecover(ctx context.Context, method string, err *error) {
+	if r := recover(); r != nil {
+		logger.WithContext(ctx).Error(fmt.Sprintf("[Recovery] %s grpc %s panic recovered:\n%s\n%s%s",
+			formatTime(time.Now()), method, r, stack(4), reset))
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+func grpcRecoveryUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer grpcRecover(ctx, info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func grpcRecoveryStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer grpcRecover(ss.Context(), info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+// chainUnary runs the interceptors in order, the first one is the outermost
+func chainUnary(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+func chainStream(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, inner)
+			}
+		}
+		return next(srv, ss)
+	}
+}
+
+// contextStream replaces the context of a stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcAndHttpOnOnePort(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	gin.SetMode(gin.TestMode)
+	srv := &ApiServer{Addr: "127.0.0.1:0"}
+	srv.RegisterRouters(func(engine *gin.Engine) {
+		engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	})
+	//the health service is always registered
+	srv.RegisterGrpcService(func(s *grpc.Server) {})
+	addrChan := make(chan net.Addr, 1)
+	srv.onListen(func(addr net.Addr) { addrChan <- addr })
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	addr := (<-addrChan).String()
+
+	resp, err := http.Get("http://" + addr + "/ping")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	check, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check.Status)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.Nil(t, srv.Shutdown(context.Background()))
+	assert.Nil(t, <-serveErr)
+}
+
+func TestGrpcInterceptors(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	var order []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+	chain := chainUnary([]grpc.UnaryServerInterceptor{record("first"), grpcRecoveryUnary, record("second")})
+	_, err := chain(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/order.Order/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestPeerIP(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", peerIP(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51234}))
+	assert.Equal(t, "::1", peerIP(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 51234}))
+	assert.Equal(t, "/run/api.sock", peerIP(&net.UnixAddr{Name: "/run/api.sock", Net: "unix"}))
+}
+
+// slowWatch is a server streaming rpc which answers once release is closed
+func slowWatch(started, release chan struct{}) grpc.ServiceDesc {
+	return grpc.ServiceDesc{
+		ServiceName: "test.Slow",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Watch",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &healthpb.HealthCheckRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				close(started)
+				<-release
+				return stream.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+			},
+		}},
+	}
+}
+
+func TestGrpcTlsShutdown(t *testing.T) {
+	dir := t.TempDir()
+	logger.Init("error", "console", "", filepath.Join(dir, "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	ca := newTestCert(t, "ca", 1, nil)
+	c := &common.Config{}
+	c.System.Tls = common.Tls{Enable: true, CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key"), MinVersion: "1.2"}
+	newTestCert(t, "server", 2, ca).write(t, c.System.Tls.CertFile, c.System.Tls.KeyFile)
+	srv, err := NewBuilder().Env(common.Environment("testing")).Config(c).Addr("127.0.0.1:0").ShareClients().Build()
+	assert.Nil(t, err)
+	started, release := make(chan struct{}), make(chan struct{})
+	desc := slowWatch(started, release)
+	srv.RegisterGrpcService(func(s *grpc.Server) { s.RegisterService(&desc, struct{}{}) })
+	addrChan := make(chan net.Addr, 1)
+	srv.onListen(func(addr net.Addr) { addrChan <- addr })
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	addr := (<-addrChan).String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots})), grpc.WithBlock())
+	assert.Nil(t, err)
+	defer conn.Close()
+	stream, err := conn.NewStream(ctx, &desc.Streams[0], "/test.Slow/Watch")
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(&healthpb.HealthCheckRequest{}))
+	assert.Nil(t, stream.CloseSend())
+	<-started
+
+	//the drain waits for the in-flight rpc of the tls connection
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- srv.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("shutdown returned before the rpc, error:%v", err)
+	case <-time.After(shutdownWait + 200*time.Millisecond):
+	}
+	close(release)
+	resp := &healthpb.HealthCheckResponse{}
+	assert.Nil(t, stream.RecvMsg(resp))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	assert.Nil(t, <-shutdownErr)
+	assert.Nil(t, <-serveErr)
+}
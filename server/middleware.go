@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/tracing"
+	"go.uber.org/zap"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -12,6 +15,82 @@ import (
 	"time"
 )
 
+// traceMiddleware accepts or generates the request id and the W3C traceparent,
+// stores them in the gin and request contexts and echoes them in the response headers
+func (srv *ApiServer) traceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := tracing.FromHeader(c.Request.Header)
+		c.Set(tracing.ContextKey, t)
+		c.Request = c.Request.WithContext(tracing.NewContext(c.Request.Context(), t))
+		t.Inject(c.Writer.Header())
+		c.Next()
+	}
+}
+
+// accessLogMiddleware writes one log entry per request when log.access.enable is set,
+// the config is read for every request so that it follows the reloads
+func (srv *ApiServer) accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		config := srv.config()
+		if config == nil || !config.Log.Access.Enable {
+			return
+		}
+		access := config.Log.Access
+		path, route := c.Request.URL.Path, c.FullPath()
+		if matchPath(access.ExcludePaths, path) {
+			return
+		}
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		slow := access.SlowThreshold > 0 && latency >= access.SlowThreshold
+		//errors and slow requests are never sampled out
+		if !slow && status < http.StatusInternalServerError && access.SampleRate < 1 &&
+			matchPath(access.SampleRoutes, route) && rand.Float64() >= access.SampleRate {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("route", route),
+			zap.String("path", path),
+			zap.Int("status", status),
+			zap.Duration("latency", latency),
+			zap.Int("bytes", c.Writer.Size()),
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("user_agent", c.Request.UserAgent()),
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+		log := logger.WithContext(c)
+		if log == nil {
+			return
+		}
+		if slow {
+			log.Warn("access:slow request", fields...)
+		} else {
+			log.Info("access", fields...)
+		}
+	}
+}
+
+// matchPath reports whether path equals one of the patterns or starts with a pattern ending with *
+func matchPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
 // ApiRecovery recovery any panics and writes a 500 if there was one.
 func (srv *ApiServer) apiRecoveryMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -37,9 +116,9 @@ func (srv *ApiServer) apiRecoveryMiddleware() gin.HandlerFunc {
 				}
 
 				if brokenPipe {
-					logger.GetLogger().Error(fmt.Sprintf("%s\n%s%s", err, string(httpRequest), reset))
+					logger.WithContext(c).Error(fmt.Sprintf("%s\n%s%s", err, string(httpRequest), reset))
 				} else {
-					logger.GetLogger().Error(fmt.Sprintf("[Recovery] %s panic recovered:\n%s\n%s%s",
+					logger.WithContext(c).Error(fmt.Sprintf("[Recovery] %s panic recovered:\n%s\n%s%s",
 						formatTime(time.Now()), err, stack, reset))
 				}
 				if brokenPipe {
@@ -54,19 +133,12 @@ func (srv *ApiServer) apiRecoveryMiddleware() gin.HandlerFunc {
 	}
 }
 
-//跨域
+//跨域，按照system.cors配置处理，配置变更后自动更新
 func (srv *ApiServer) cors() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		method := c.Request.Method
-		origin := c.Request.Header.Get("Origin")
-		c.Header("Access-Control-Allow-Origin", origin)
-		c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token,Authorization,X-User-Id")
-		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS,DELETE,PUT")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
-		c.Header("Access-Control-Allow-Credentials", "true")
-
-		if method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
+		policy, _ := srv.corsPolicy.Load().(*corsPolicy)
+		if policy != nil && !policy.handle(c) {
+			return
 		}
 		c.Next()
 	}
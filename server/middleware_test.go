@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	previous := logger.Set(zap.New(core))
+	defer logger.Set(previous)
+
+	config := &common.Config{}
+	config.Log.Access = common.AccessLog{
+		Enable:        true,
+		ExcludePaths:  []string{"/health", "/static/*"},
+		SampleRoutes:  []string{"/sampled"},
+		SampleRate:    0,
+		SlowThreshold: 20 * time.Millisecond,
+	}
+	srv := &ApiServer{staticConfig: config}
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(srv.traceMiddleware(), srv.accessLogMiddleware())
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	engine.GET("/health", ok)
+	engine.GET("/static/*file", ok)
+	engine.GET("/user/:id", ok)
+	engine.GET("/sampled", func(c *gin.Context) {
+		switch c.Query("case") {
+		case "error":
+			_ = c.Error(assert.AnError)
+			c.String(http.StatusInternalServerError, "error")
+		case "slow":
+			time.Sleep(30 * time.Millisecond)
+			c.String(http.StatusOK, "slow")
+		default:
+			c.String(http.StatusOK, "ok")
+		}
+	})
+	serve := func(target string) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("User-Agent", "test-agent")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	//excluded paths and sampled out requests are not logged
+	for _, target := range []string{"/health", "/static/app.js", "/sampled", "/sampled"} {
+		serve(target)
+	}
+	assert.Equal(t, 0, logs.Len())
+
+	serve("/user/1?x=1")
+	entries := logs.TakeAll()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	assert.Equal(t, "access", entries[0].Message)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "GET", fields["method"])
+	assert.Equal(t, "/user/:id", fields["route"])
+	assert.Equal(t, "/user/1", fields["path"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Equal(t, int64(2), fields["bytes"])
+	assert.Equal(t, "test-agent", fields["user_agent"])
+	assert.NotEmpty(t, fields["client_ip"])
+	assert.NotEmpty(t, fields["request_id"])
+	assert.Contains(t, fields, "latency")
+	assert.NotContains(t, fields, "errors")
+
+	//errors and slow requests are never sampled out, slow ones are logged at warn
+	serve("/sampled?case=error")
+	serve("/sampled?case=slow")
+	entries = logs.TakeAll()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	assert.Equal(t, int64(http.StatusInternalServerError), entries[0].ContextMap()["status"])
+	assert.Contains(t, entries[0].ContextMap()["errors"], assert.AnError.Error())
+	assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+	assert.Equal(t, "access:slow request", entries[1].Message)
+
+	//the config is read for every request
+	config.Log.Access.Enable = false
+	serve("/user/1")
+	assert.Equal(t, 0, logs.Len())
+}
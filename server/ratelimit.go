@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/redisclient"
+	"github.com/tmnhs/common/utils"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateLimitKeyPrefix = "rate-limit:"
+	//gin context key set by RateLimitMiddleware once the rules keyed by user are applied
+	userRateLimitedKey = "user-rate-limited"
+	//idle local buckets are dropped after this duration
+	rateLimitIdleTimeout = 10 * time.Minute
+)
+
+// rateLimiter takes a token from the bucket of key, it returns how long to wait when the bucket is empty
+type rateLimiter interface {
+	Allow(ctx context.Context, key string, limit float64, burst int) (bool, time.Duration, error)
+}
+
+// localLimiter keeps the token buckets in process
+type localLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*localBucket
+	lastSweep time.Time
+}
+
+type localBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLocalLimiter() *localLimiter {
+	return &localLimiter{buckets: make(map[string]*localBucket), lastSweep: time.Now()}
+}
+
+func (l *localLimiter) Allow(ctx context.Context, key string, limit float64, burst int) (bool, time.Duration, error) {
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > rateLimitIdleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > rateLimitIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	//the limit is part of the key so that a config change starts new buckets
+	bucketKey := fmt.Sprintf("%s|%v|%d", key, limit, burst)
+	b, ok := l.buckets[bucketKey]
+	if !ok {
+		b = &localBucket{limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+		l.buckets[bucketKey] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return false, 0, nil
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay, nil
+	}
+	return true, 0, nil
+}
+
+// redisLimiter shares the token buckets between instances through redisclient
+type redisLimiter struct{}
+
+func (redisLimiter) Allow(ctx context.Context, key string, limit float64, burst int) (bool, time.Duration, error) {
+	return redisclient.AllowN(ctx, key, limit, burst, 1)
+}
+
+// rateLimitMiddleware applies the rules of system.rate-limit which are not keyed by user, the config is read for
+// every request so that it follows the reloads. Rejected requests get 429 with Retry-After, the request is let
+// through when redis fails.
+func (srv *ApiServer) rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if srv.limitRequest(c, false) {
+			c.Next()
+		}
+	}
+}
+
+// userRateLimitMiddleware applies the rules keyed by user after the middlewares of RegisterMiddlewares,
+// unless RateLimitMiddleware is mounted: the rules keyed by user are then applied by it only
+func (srv *ApiServer) userRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&srv.userRateLimitMounted) == 1 || srv.limitRequest(c, true) {
+			c.Next()
+		}
+	}
+}
+
+// RateLimitMiddleware applies the rules of system.rate-limit keyed by user, mount it after the auth middleware
+// of a route group, e.g. engine.Group("/api", auth.JWTAuth(), srv.RateLimitMiddleware()). The rules keyed by user
+// are applied after the middlewares of RegisterMiddlewares otherwise, before the user id of such a group is set.
+// Once it is mounted, the rules keyed by user only apply to the routes it is mounted on.
+func (srv *ApiServer) RateLimitMiddleware() gin.HandlerFunc {
+	atomic.StoreInt32(&srv.userRateLimitMounted, 1)
+	return func(c *gin.Context) {
+		//applied once when it is mounted on nested groups
+		if _, ok := c.Get(userRateLimitedKey); ok {
+			c.Next()
+			return
+		}
+		c.Set(userRateLimitedKey, true)
+		if srv.limitRequest(c, true) {
+			c.Next()
+		}
+	}
+}
+
+// limitRequest applies the rule of the request if it is keyed by user or not, false when the request has been rejected
+func (srv *ApiServer) limitRequest(c *gin.Context, byUser bool) bool {
+	config := srv.config()
+	if config == nil || !config.System.RateLimit.Enable {
+		return true
+	}
+	srv.rateLimitOnce.Do(func() {
+		srv.rateLimitLocal = newLocalLimiter()
+	})
+	return limitRequest(c, config.System.RateLimit, rateLimitPrefix(config), srv.rateLimitLocal, byUser)
+}
+
+// rateLimitPrefix returns system.rate-limit.key-prefix, or the prefix of the service name of system.registry
+func rateLimitPrefix(c *common.Config) string {
+	if c.System.RateLimit.KeyPrefix != "" {
+		return c.System.RateLimit.KeyPrefix
+	}
+	if c.System.Registry.Name != "" {
+		return c.System.Registry.Name + ":" + rateLimitKeyPrefix
+	}
+	return rateLimitKeyPrefix
+}
+
+// limitRequest returns false when the request has been rejected, only the rules keyed by user are applied when
+// byUser is set, and only the other ones otherwise
+func limitRequest(c *gin.Context, rl common.RateLimit, prefix string, local rateLimiter, byUser bool) bool {
+	route, limit, burst, keyBy := matchRateLimit(rl, c.Request.Method, c.FullPath())
+	if limit <= 0 || (keyBy == "user") != byUser {
+		return true
+	}
+	key := prefix + route + ":" + rateLimitKey(c, rl, keyBy)
+
+	limiter := local
+	if rl.Store == "redis" {
+		limiter = redisLimiter{}
+	}
+	allowed, retryAfter, err := limiter.Allow(c.Request.Context(), key, limit, burst)
+	if err != nil {
+		logger.WithContext(c).Error(fmt.Sprintf("rate limit error, the request is allowed, key:%s error:%s", key, err.Error()))
+		return true
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		common.FailWithStatus(http.StatusTooManyRequests, common.ErrorTooManyRequests, "too many requests", c)
+		return false
+	}
+	return true
+}
+
+// matchRateLimit returns the first route rule matching the request, or the default limit
+func matchRateLimit(rl common.RateLimit, method, fullPath string) (route string, limit float64, burst int, keyBy string) {
+	route, limit, burst, keyBy = "*", rl.Rate, rl.Burst, rl.KeyBy
+	for _, rule := range rl.Routes {
+		pattern := rule.Route
+		if idx := strings.Index(pattern, " "); idx >= 0 {
+			if !strings.EqualFold(pattern[:idx], method) {
+				continue
+			}
+			pattern = strings.TrimSpace(pattern[idx+1:])
+		}
+		if matchPath([]string{pattern}, fullPath) {
+			route, limit, burst = rule.Route, rule.Rate, rule.Burst
+			if rule.KeyBy != "" {
+				keyBy = rule.KeyBy
+			}
+			break
+		}
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+	return
+}
+
+// rateLimitKey identifies the client, the client ip is used when the user id or the api key is missing
+func rateLimitKey(c *gin.Context, rl common.RateLimit, keyBy string) string {
+	switch keyBy {
+	case "user":
+		if user, ok := c.Get(rl.UserKey); ok && fmt.Sprint(user) != "" {
+			return "user:" + fmt.Sprint(user)
+		}
+	case "api-key":
+		if apiKey := c.GetHeader(rl.APIHeader); apiKey != "" {
+			//the api key itself is not stored
+			return "api-key:" + utils.MD5(apiKey)
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,46 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/server"
+	"github.com/tmnhs/common/servertest"
+)
+
+func TestRedisRateLimit(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	r := servertest.FakeRedis(t)
+	c := &common.Config{}
+	c.System.Registry.Name = "order-api"
+	c.System.RateLimit = common.RateLimit{Enable: true, Store: "redis", Rate: 0.001, Burst: 1, KeyBy: "ip"}
+	srv, err := server.NewBuilder().Env(common.Environment("testing")).Config(c).ShareClients().Build()
+	assert.Nil(t, err)
+	srv.RegisterRouters(func(engine *gin.Engine) {
+		engine.GET("/orders", func(c *gin.Context) { common.Ok(c) })
+	})
+	engine := srv.BuildEngine()
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+	w := serve()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	//the bucket is shared through redis, its key starts with the service name
+	keys := r.Keys()
+	assert.Len(t, keys, 1)
+	assert.True(t, strings.HasPrefix(keys[0], "order-api:rate-limit:"), keys)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/auth"
+)
+
+func TestLimitRequest(t *testing.T) {
+	rl := common.RateLimit{
+		Enable:    true,
+		Rate:      100,
+		KeyBy:     "ip",
+		APIHeader: "X-Api-Key",
+		Routes: []common.RateLimitRule{
+			{Route: "POST /login", Rate: 1, Burst: 2, KeyBy: "api-key"},
+		},
+	}
+	local := newLocalLimiter()
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		if limitRequest(c, rl, rateLimitKeyPrefix, local, false) {
+			c.Next()
+		}
+	})
+	engine.POST("/login", func(c *gin.Context) { common.Ok(c) })
+	serve := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set("X-Api-Key", apiKey)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("a").Code)
+	assert.Equal(t, http.StatusOK, serve("a").Code)
+	w := serve("a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	var resp common.Response
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, common.ErrorTooManyRequests, resp.Code)
+	//another api key has its own bucket
+	assert.Equal(t, http.StatusOK, serve("b").Code)
+
+	route, limit, burst, keyBy := matchRateLimit(rl, http.MethodGet, "/login")
+	assert.Equal(t, "*", route)
+	assert.Equal(t, 100.0, limit)
+	assert.Equal(t, 100, burst)
+	assert.Equal(t, "ip", keyBy)
+}
+
+func TestUserRateLimit(t *testing.T) {
+	key, err := auth.NewHMACKey("k1", "HS256", []byte("secret"))
+	assert.Nil(t, err)
+	m, err := auth.NewManager(auth.WithKey(key))
+	assert.Nil(t, err)
+	token := func(user string) string {
+		token, _, err := m.Issue(auth.Claims{UserID: user})
+		assert.Nil(t, err)
+		return token
+	}
+	config := &common.Config{}
+	config.System.RateLimit = common.RateLimit{Enable: true, Rate: 0.001, Burst: 1, KeyBy: "user", UserKey: auth.UserIDKey,
+		Routes: []common.RateLimitRule{{Route: "/public", Rate: 0.001, Burst: 1, KeyBy: "ip"}}}
+	gin.SetMode(gin.TestMode)
+	serve := func(engine *gin.Engine, path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	//auth.JWTAuth mounted with RegisterMiddleware, the users behind one ip have their own buckets
+	srv := &ApiServer{staticConfig: config}
+	srv.RegisterMiddleware(func(engine *gin.Engine) { engine.Use(m.Middleware()) })
+	srv.RegisterRouters(func(engine *gin.Engine) {
+		engine.GET("/user", func(c *gin.Context) { common.Ok(c) })
+	})
+	engine := srv.BuildEngine()
+	alice, bob := token("alice"), token("bob")
+	assert.Equal(t, http.StatusOK, serve(engine, "/user", alice))
+	assert.Equal(t, http.StatusTooManyRequests, serve(engine, "/user", alice))
+	assert.Equal(t, http.StatusOK, serve(engine, "/user", bob))
+
+	//auth on a route group with RateLimitMiddleware, the rules keyed by user only apply to the group,
+	//the rules keyed by ip apply to every route
+	srv = &ApiServer{staticConfig: config}
+	srv.RegisterRouters(func(engine *gin.Engine) {
+		engine.GET("/public", func(c *gin.Context) { common.Ok(c) })
+		engine.GET("/anonymous", func(c *gin.Context) { common.Ok(c) })
+		api := engine.Group("/api", m.Middleware(), srv.RateLimitMiddleware())
+		api.GET("/user", func(c *gin.Context) { common.Ok(c) })
+		//mounted twice, the token is taken once
+		api.GET("/nested", srv.RateLimitMiddleware(), func(c *gin.Context) { common.Ok(c) })
+	})
+	engine = srv.BuildEngine()
+	assert.Equal(t, http.StatusOK, serve(engine, "/api/user", alice))
+	assert.Equal(t, http.StatusTooManyRequests, serve(engine, "/api/user", alice))
+	assert.Equal(t, http.StatusOK, serve(engine, "/api/user", bob))
+	assert.Equal(t, http.StatusOK, serve(engine, "/public", ""))
+	assert.Equal(t, http.StatusTooManyRequests, serve(engine, "/public", ""))
+	assert.Equal(t, http.StatusOK, serve(engine, "/anonymous", ""))
+	assert.Equal(t, http.StatusOK, serve(engine, "/anonymous", ""))
+	carol := token("carol")
+	assert.Equal(t, http.StatusOK, serve(engine, "/api/nested", carol))
+	assert.Equal(t, http.StatusTooManyRequests, serve(engine, "/api/nested", carol))
+}
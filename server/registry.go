@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/health"
+	"github.com/tmnhs/common/utils"
+)
+
+// optionFailed makes Builder.Build return err, the first error is kept
+func (srv *ApiServer) optionFailed(err error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.optionErr == nil {
+		srv.optionErr = err
+	}
+}
+
+// onListen adds a hook run once the listener is up
+func (srv *ApiServer) onListen(hook func(addr net.Addr)) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.listenHooks = append(srv.listenHooks, hook)
+}
+
+// serviceInstance describes the server in the registry, system.registry.host and port override the local ip and the listen port
+func (srv *ApiServer) serviceInstance(c *common.Config, addr net.Addr) (*etcdclient.ServiceInstance, error) {
+	registryConfig := c.System.Registry
+	host := registryConfig.Host
+	if host == "" {
+		ip, err := utils.LocalIP()
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+	port := registryConfig.Port
+	if port == 0 {
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			port = tcpAddr.Port
+		} else {
+			port = c.System.Addr
+		}
+	}
+	version := c.System.Version
+	if version == "" {
+		version = common.Version
+	}
+	env := srv.env.String()
+	if c.System.Env != "" {
+		env = c.System.Env
+	}
+	return &etcdclient.ServiceInstance{
+		Name:      registryConfig.Name,
+		Addr:      net.JoinHostPort(host, strconv.Itoa(port)),
+		Version:   version,
+		Env:       env,
+		Weight:    registryConfig.Weight,
+		Zone:      registryConfig.Zone,
+		StartTime: time.Now(),
+	}, nil
+}
+
+// registerCheck adds a check to the health registry of the server
+func (srv *ApiServer) registerCheck(name string, fn health.CheckFunc) {
+	srv.healthRegistry().Register(name, fn)
+}
@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/server"
+	"github.com/tmnhs/common/servertest"
+)
+
+func TestWithRegistry(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	c := &common.Config{}
+	c.System.Registry = common.Registry{Name: "order-api", Prefix: "/services/", Host: "127.0.0.1", Ttl: 10}
+	build := func(c *common.Config) (*server.ApiServer, error) {
+		return server.NewBuilder().Env(common.Environment("testing")).Config(c).Addr("127.0.0.1:0").
+			ShareClients().Options(server.WithRegistry()).Build()
+	}
+
+	//etcd is not initialized
+	previous := etcdclient.Set(nil)
+	_, err := build(c)
+	etcdclient.Set(previous)
+	assert.NotNil(t, err)
+
+	servertest.FakeEtcd(t)
+	noName := *c
+	noName.System.Registry.Name = ""
+	_, err = build(&noName)
+	assert.NotNil(t, err)
+
+	srv, err := build(c)
+	assert.Nil(t, err)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	var kvs int
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline) && kvs == 0; time.Sleep(10 * time.Millisecond) {
+		resp, err := etcdclient.Get("/services/order-api/127.0.0.1:", clientv3.WithPrefix())
+		assert.Nil(t, err)
+		kvs = len(resp.Kvs)
+	}
+	assert.Equal(t, 1, kvs)
+
+	//the instance is deregistered by the shutdown
+	assert.Nil(t, srv.Shutdown(context.Background()))
+	assert.Nil(t, <-serveErr)
+	resp, err := etcdclient.Get("/services/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Kvs)
+}
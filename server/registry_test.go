@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+)
+
+func TestServiceInstance(t *testing.T) {
+	c := &common.Config{}
+	c.System.Addr = 8080
+	c.System.Version = "v2.0.0"
+	c.System.Registry = common.Registry{Name: "order-api", Prefix: "/services/", Host: "10.0.0.8", Weight: 50, Zone: "sh-1"}
+	srv := &ApiServer{env: common.Environment("testing")}
+
+	instance, err := srv.serviceInstance(c, &net.TCPAddr{IP: net.IPv6zero, Port: 9090})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.8:9090", instance.Addr)
+	assert.Equal(t, "v2.0.0", instance.Version)
+	assert.Equal(t, "testing", instance.Env)
+	assert.Equal(t, 50, instance.Weight)
+	assert.Equal(t, "/services/order-api/10.0.0.8:9090", instance.Key(c.System.Registry.Prefix))
+
+	c.System.Registry.Port = 80
+	instance, err = srv.serviceInstance(c, &net.TCPAddr{Port: 9090})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.8:80", instance.Addr)
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tmnhs/common/dbclient"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/logger"
+	"github.com/tmnhs/common/notify"
+	"github.com/tmnhs/common/redisclient"
+)
+
+// ShutdownPhase orders the shutdown hooks, the phases run in ascending order,
+// a hook may use a value between two phases, e.g. PhaseHooks+10
+type ShutdownPhase int
+
+const (
+	PhaseDeregister   ShutdownPhase = 100 // the instance leaves the service registry before the traffic is drained, the hooks of RegisterShutdown run first
+	PhaseStopAccept   ShutdownPhase = 200 // readiness goes false, keep-alives are disabled
+	PhaseDrain        ShutdownPhase = 300 // the listener is closed and the in-flight requests and rpcs finish
+	PhaseHooks        ShutdownPhase = 400 // the hooks which need the requests to be finished, e.g. stopping a consumer
+	PhaseFlushNotify  ShutdownPhase = 500 // the queued notify messages are sent
+	PhaseCloseClients ShutdownPhase = 600 // mysql, redis and etcd are closed
+	PhaseSyncLogs     ShutdownPhase = 700 // the logger is synced after the shutdown report
+)
+
+const (
+	//timeout of a hook registered without Timeout
+	shutdownHookTimeout = shutdownMaxAge / 3
+	//the in-flight requests may use half of the shutdown
+	shutdownDrainTimeout = shutdownMaxAge / 2
+)
+
+func (p ShutdownPhase) String() string {
+	switch p {
+	case PhaseStopAccept:
+		return "stop-accept"
+	case PhaseDrain:
+		return "drain"
+	case PhaseHooks:
+		return "hooks"
+	case PhaseDeregister:
+		return "deregister"
+	case PhaseFlushNotify:
+		return "flush-notify"
+	case PhaseCloseClients:
+		return "close-clients"
+	case PhaseSyncLogs:
+		return "sync-logs"
+	}
+	return fmt.Sprintf("phase-%d", int(p))
+}
+
+// ShutdownHook runs during Shutdown, its context is done after Timeout or when the shutdown context is done
+type ShutdownHook struct {
+	Name    string
+	Phase   ShutdownPhase
+	Timeout time.Duration
+	Fn      func(ctx context.Context) error
+}
+
+// ShutdownResult is the result of one hook in the shutdown report
+type ShutdownResult struct {
+	Phase    ShutdownPhase
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// ShutdownReport lists the hooks in the order they ran
+type ShutdownReport struct {
+	Start   time.Time
+	Results []ShutdownResult
+}
+
+func (r *ShutdownReport) Failed() []ShutdownResult {
+	var failed []ShutdownResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err returns nil when every hook succeeded
+func (r *ShutdownReport) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(failed))
+	for _, result := range failed {
+		msgs = append(msgs, fmt.Sprintf("%s/%s: %s", result.Phase, result.Name, result.Err.Error()))
+	}
+	return fmt.Errorf("shutdown: %d hook(s) failed: %s", len(failed), strings.Join(msgs, "; "))
+}
+
+func (r *ShutdownReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "shutdown report, %d hook(s) in %s, %d failed:", len(r.Results), time.Since(r.Start).Round(time.Millisecond), len(r.Failed()))
+	for _, result := range r.Results {
+		status := "ok"
+		if result.Err != nil {
+			status = "error:" + result.Err.Error()
+		}
+		fmt.Fprintf(&b, "\n  [%s] %s %s %s", result.Phase, result.Name, result.Duration.Round(time.Millisecond), status)
+	}
+	return b.String()
+}
+
+// RegisterShutdownHook adds a hook to the shutdown pipeline, the hooks of a phase run in the order they are registered
+func (srv *ApiServer) RegisterShutdownHook(hooks ...ShutdownHook) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.shutdownHooks = append(srv.shutdownHooks, hooks...)
+}
+
+// Shutdown runs the shutdown phases once, the next calls wait for the first one and return its error.
+// ListenAndServe returns after the shutdown is done.
+func (srv *ApiServer) Shutdown(ctx context.Context) error {
+	srv.shutdownOnce.Do(func() {
+		srv.mu.Lock()
+		srv.shuttingDown = true
+		srv.mu.Unlock()
+		report := srv.runShutdown(ctx)
+		srv.shutdownErr = report.Err()
+		srv.mu.Lock()
+		close(srv.getDoneChanLocked())
+		srv.mu.Unlock()
+	})
+	<-srv.getDoneChan()
+	return srv.shutdownErr
+}
+
+func (srv *ApiServer) runShutdown(ctx context.Context) *ShutdownReport {
+	report := &ShutdownReport{Start: time.Now()}
+	logged := false
+	for _, hook := range srv.shutdownPipeline() {
+		if hook.Phase >= PhaseSyncLogs && !logged {
+			//the report is written before the logger is synced
+			srv.logShutdownReport(report)
+			logged = true
+		}
+		report.Results = append(report.Results, runShutdownHook(ctx, hook))
+	}
+	if !logged {
+		srv.logShutdownReport(report)
+	}
+	return report
+}
+
+// shutdownPipeline returns the built-in hooks and the registered ones sorted by phase
+func (srv *ApiServer) shutdownPipeline() []ShutdownHook {
+	hooks := []ShutdownHook{
+		{Name: "readiness", Phase: PhaseStopAccept, Fn: srv.stopAccept},
+		{Name: "http-server", Phase: PhaseDrain, Timeout: shutdownDrainTimeout, Fn: srv.drain},
+		{Name: "config-subscriptions", Phase: PhaseCloseClients, Fn: srv.cancelConfigSubscriptions},
+	}
+	srv.mu.Lock()
+	//the hooks of RegisterShutdown keep running before the traffic is drained
+	for i, shutdown := range srv.Shutdowns {
+		shutdown := shutdown
+		hooks = append(hooks, ShutdownHook{Name: fmt.Sprintf("shutdown-%d", i), Phase: PhaseDeregister, Fn: func(ctx context.Context) error {
+			shutdown(srv)
+			return nil
+		}})
+	}
+	hooks = append(hooks, srv.shutdownHooks...)
+	srv.mu.Unlock()
+	//the singletons may be used by the other servers of the process, see Builder.ShareClients
+	if !srv.settings.shareClients {
+		hooks = append(hooks,
+			ShutdownHook{Name: "notify", Phase: PhaseFlushNotify, Fn: notify.Close},
+			ShutdownHook{Name: "mysql", Phase: PhaseCloseClients, Fn: closeFn(dbclient.Close)},
+			ShutdownHook{Name: "redis", Phase: PhaseCloseClients, Fn: closeFn(redisclient.Close)},
+			ShutdownHook{Name: "etcd", Phase: PhaseCloseClients, Fn: closeFn(etcdclient.Close)},
+			ShutdownHook{Name: "logger", Phase: PhaseSyncLogs, Fn: syncLogger},
+		)
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Phase < hooks[j].Phase
+	})
+	return hooks
+}
+
+func runShutdownHook(ctx context.Context, hook ShutdownHook) (result ShutdownResult) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = shutdownHookTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	result = ShutdownResult{Phase: hook.Phase, Name: hook.Name}
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errCh <- hook.Fn(hookCtx)
+	}()
+	select {
+	case result.Err = <-errCh:
+	case <-hookCtx.Done():
+		//the hook ignores its context, the shutdown goes on without it
+		result.Err = hookCtx.Err()
+	}
+	return result
+}
+
+// stopAccept takes the instance out of the load balancers and waits for them to notice
+func (srv *ApiServer) stopAccept(ctx context.Context) error {
+	srv.healthRegistry().SetReady(false)
+	if srv.HttpServer != nil {
+		srv.HttpServer.SetKeepAlivesEnabled(false)
+	}
+	select {
+	case <-time.After(shutdownWait):
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// drain closes the listener and waits for the in-flight requests and rpcs
+func (srv *ApiServer) drain(ctx context.Context) error {
+	grpcErr := make(chan error, 1)
+	if srv.grpcServer != nil && !srv.grpcOverHttp {
+		go func() {
+			grpcErr <- stopGrpc(ctx, srv.grpcServer)
+		}()
+	} else {
+		grpcErr <- nil
+	}
+	var err error
+	if srv.HttpServer != nil {
+		err = srv.HttpServer.Shutdown(ctx)
+	}
+	if srv.grpcServer != nil && srv.grpcOverHttp {
+		//the rpcs of the tls connections are drained by the http server, Stop cancels the ones left when ctx is done
+		srv.grpcServer.Stop()
+	}
+	if e := <-grpcErr; e != nil && err == nil {
+		err = fmt.Errorf("grpc: %w", e)
+	}
+	return err
+}
+
+func (srv *ApiServer) logShutdownReport(report *ShutdownReport) {
+	if l := logger.GetLogger(); l != nil {
+		if len(report.Failed()) > 0 {
+			l.Error(report.String())
+		} else {
+			l.Info(report.String())
+		}
+		return
+	}
+	fmt.Println(report.String())
+}
+
+func syncLogger(ctx context.Context) error {
+	if logger.GetLogger() == nil {
+		return nil
+	}
+	logger.Shutdown()
+	return nil
+}
+
+func closeFn(close func() error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return close()
+	}
+}
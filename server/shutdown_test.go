@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/health"
+	"github.com/tmnhs/common/logger"
+)
+
+func TestShutdown(t *testing.T) {
+	srv := &ApiServer{}
+	var order []string
+	hook := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	srv.RegisterShutdownHook(
+		ShutdownHook{Name: "deregister", Phase: PhaseDeregister, Fn: hook("deregister", nil)},
+		ShutdownHook{Name: "flush", Phase: PhaseHooks + 10, Fn: hook("flush", errors.New("flush failed"))},
+		ShutdownHook{Name: "slow", Phase: PhaseHooks, Timeout: 10 * time.Millisecond, Fn: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	)
+	srv.RegisterShutdown(func(*ApiServer) {
+		order = append(order, "legacy")
+	})
+	health.SetReady(true)
+
+	err := srv.Shutdown(context.Background())
+	assert.False(t, health.Ready())
+	assert.Equal(t, []string{"legacy", "deregister", "flush"}, order)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "2 hook(s) failed")
+	assert.Contains(t, err.Error(), "hooks/slow: context deadline exceeded")
+	assert.Contains(t, err.Error(), "phase-410/flush: flush failed")
+
+	//the pipeline only runs once
+	assert.Equal(t, err, srv.Shutdown(context.Background()))
+	assert.Len(t, order, 3)
+}
+
+func TestHttpServerShutdown(t *testing.T) {
+	logger.Init("error", "console", "", filepath.Join(t.TempDir(), "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	srv, err := NewBuilder().Env(common.Environment("testing")).Config(&common.Config{}).Addr("127.0.0.1:0").
+		HealthRegistry(health.NewRegistry()).ShareClients().Build()
+	assert.Nil(t, err)
+	listening := make(chan struct{})
+	srv.onListen(func(addr net.Addr) { close(listening) })
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	<-listening
+
+	//the http server is shut down directly, ListenAndServe returns without the shutdown pipeline
+	assert.Nil(t, srv.HttpServer.Shutdown(context.Background()))
+	select {
+	case err := <-serveErr:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ListenAndServe does not return")
+	}
+}
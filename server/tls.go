@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+)
+
+const (
+	// PeerIdentityKey is the gin context key of the *PeerIdentity of a mutual tls client
+	PeerIdentityKey = "peer-identity"
+
+	//the writes of a rotation are reloaded together
+	tlsReloadDelay = 200 * time.Millisecond
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// PeerIdentity is the verified certificate of a mutual tls client
+type PeerIdentity struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	URIs         []string // e.g. spiffe://cluster.local/ns/default/sa/api
+	SerialNumber string
+	Fingerprint  string // sha256 of the certificate, hex encoded
+	NotAfter     time.Time
+}
+
+// PeerIdentityFromContext returns the identity of the client certificate, false when the client presented none
+func PeerIdentityFromContext(c *gin.Context) (*PeerIdentity, bool) {
+	v, ok := c.Get(PeerIdentityKey)
+	if !ok {
+		return nil, false
+	}
+	identity, ok := v.(*PeerIdentity)
+	return identity, ok
+}
+
+func newPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	sum := sha256.Sum256(cert.Raw)
+	identity := &PeerIdentity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		SerialNumber: cert.SerialNumber.String(),
+		Fingerprint:  hex.EncodeToString(sum[:]),
+		NotAfter:     cert.NotAfter,
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity
+}
+
+// peerIdentityMiddleware puts the identity of the client certificate on the gin context
+func (srv *ApiServer) peerIdentityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if state := c.Request.TLS; state != nil && len(state.PeerCertificates) > 0 {
+			c.Set(PeerIdentityKey, newPeerIdentity(state.PeerCertificates[0]))
+		}
+		c.Next()
+	}
+}
+
+// certReloader serves the certificate and the client CAs of the files, they are reloaded when the files change,
+// the previous ones are kept when the new files are invalid
+type certReloader struct {
+	certFile, keyFile, caFile string
+	cert                      atomic.Value // *tls.Certificate
+	clientCAs                 atomic.Value // *x509.CertPool
+	watcher                   *fsnotify.Watcher
+	closeOnce                 sync.Once
+}
+
+func newCertReloader(c common.Tls) (*certReloader, error) {
+	r := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile, caFile: c.ClientCaFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate %s: %w", r.certFile, err)
+	}
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("load client ca %s: %w", r.caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load client ca %s: no certificate found", r.caFile)
+		}
+		r.clientCAs.Store(pool)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load().(*tls.Certificate), nil
+}
+
+// watch reloads the files when they change, the directories are watched so that
+// a rename or a kubernetes secret update (a symlink swap) is noticed as well
+func (r *certReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		files[filepath.Clean(file)] = true
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if files[filepath.Clean(event.Name)] || filepath.Base(event.Name) == "..data" {
+					timer = time.After(tlsReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.GetLogger().Error(fmt.Sprintf("api-server:watch tls files error:%s", err.Error()))
+			case <-timer:
+				timer = nil
+				if err := r.reload(); err != nil {
+					logger.GetLogger().Error(fmt.Sprintf("api-server:reload tls files failed, keep the previous certificate, error:%s", err.Error()))
+					continue
+				}
+				logger.GetLogger().Info(fmt.Sprintf("api-server:tls certificate %s reloaded", r.certFile))
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *certReloader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		if r.watcher != nil {
+			err = r.watcher.Close()
+		}
+	})
+	return err
+}
+
+// newTlsConfig builds the server config of system.tls, the certificate and the client CAs come from the reloader
+func newTlsConfig(c common.Tls, r *certReloader) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+		//ServeTLS does not add h2 to the configs of GetConfigForClient
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls version %q", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	if len(c.CipherSuites) > 0 {
+		suites, err := cipherSuites(c.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = suites
+	}
+	if c.ClientCaFile == "" {
+		return config, nil
+	}
+
+	switch c.ClientAuth {
+	case "verify-if-given":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "", "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth %q", c.ClientAuth)
+	}
+	base := config
+	config = base.Clone()
+	//the client CAs of the last reload are used for every handshake
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		clientConfig := base.Clone()
+		clientConfig.ClientCAs = r.clientCAs.Load().(*x509.CertPool)
+		return clientConfig, nil
+	}
+	return config, nil
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, errors.New("unknown or insecure cipher suite " + name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/logger"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"common"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestMutualTls(t *testing.T) {
+	dir := t.TempDir()
+	//the reloads are logged
+	logger.Init("error", "console", "", filepath.Join(dir, "log"), false, "LowercaseLevelEncoder", "stacktrace", false)
+	ca := newTestCert(t, "ca", 1, nil)
+	c := common.Tls{
+		Enable:       true,
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCaFile: filepath.Join(dir, "ca.crt"),
+		MinVersion:   "1.2",
+	}
+	ca.write(t, c.ClientCaFile, "")
+	newTestCert(t, "server-1", 2, ca).write(t, c.CertFile, c.KeyFile)
+
+	reloader, err := newCertReloader(c)
+	assert.Nil(t, err)
+	assert.Nil(t, reloader.watch())
+	defer reloader.Close()
+	config, err := newTlsConfig(c, reloader)
+	assert.Nil(t, err)
+
+	gin.SetMode(gin.TestMode)
+	srv := &ApiServer{}
+	engine := gin.New()
+	engine.Use(srv.peerIdentityMiddleware())
+	engine.GET("/whoami", func(c *gin.Context) {
+		identity, ok := PeerIdentityFromContext(c)
+		if !ok {
+			c.String(http.StatusUnauthorized, "")
+			return
+		}
+		c.String(http.StatusOK, identity.CommonName)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	httpServer := &http.Server{Handler: engine, TLSConfig: config}
+	go httpServer.ServeTLS(listener, "", "")
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	url := "https://" + listener.Addr().String() + "/whoami"
+
+	resp, err := client(newTestCert(t, "order-service", 3, ca).tlsCertificate()).Get(url)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "order-service", string(body))
+	assert.Equal(t, "server-1", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	//the client certificate is required
+	_, err = client().Get(url)
+	assert.NotNil(t, err)
+
+	//the rotated certificate is served without a restart
+	newTestCert(t, "server-2", 4, ca).write(t, c.CertFile, c.KeyFile)
+	assert.Eventually(t, func() bool {
+		resp, err := client(newTestCert(t, "order-service", 5, ca).tlsCertificate()).Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName == "server-2"
+	}, 3*time.Second, 100*time.Millisecond)
+
+	//an invalid rotation keeps the previous certificate
+	assert.Nil(t, ioutil.WriteFile(c.KeyFile, []byte("invalid"), 0600))
+	assert.NotNil(t, reloader.reload())
+	cert, err := reloader.getCertificate(nil)
+	assert.Nil(t, err)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, "server-2", leaf.Subject.CommonName)
+}
@@ -0,0 +1,627 @@
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/tmnhs/common/etcdclient"
+)
+
+// Etcd is an in-memory etcd for the kv, txn, lease and watch apis of clientv3, the history is not kept,
+// so the reads and watches of a past revision see the current keys only
+type Etcd struct {
+	mu       sync.Mutex
+	rev      int64
+	kvs      map[string]*mvccpb.KeyValue
+	leases   map[clientv3.LeaseID]*etcdLease
+	nextID   clientv3.LeaseID
+	watchers map[*etcdWatcher]struct{}
+	//grantDelay delays the grants, see SetGrantDelay
+	grantDelay time.Duration
+}
+
+type etcdLease struct {
+	ttl      int64
+	expireAt time.Time
+	keys     map[string]struct{}
+}
+
+// FakeEtcd replaces the client of etcdclient with a client of a new Etcd until the end of the test
+func FakeEtcd(t testing.TB) *Etcd {
+	e := NewEtcd()
+	client := e.Client()
+	previous := etcdclient.Set(etcdclient.NewClient(client, 3*time.Second))
+	t.Cleanup(func() {
+		etcdclient.Set(previous)
+		//the ctx client always returns context.Canceled
+		_ = client.Close()
+	})
+	return e
+}
+
+// NewEtcd returns an empty Etcd
+func NewEtcd() *Etcd {
+	return &Etcd{
+		kvs:      make(map[string]*mvccpb.KeyValue),
+		leases:   make(map[clientv3.LeaseID]*etcdLease),
+		watchers: make(map[*etcdWatcher]struct{}),
+	}
+}
+
+// Client returns a client of e, its watches are cancelled by Close
+func (e *Etcd) Client() *clientv3.Client {
+	client := clientv3.NewCtxClient(context.Background())
+	client.KV = e
+	client.Lease = e
+	client.Watcher = &etcdWatchClient{etcd: e, ctx: client.Ctx()}
+	return client
+}
+
+// CancelWatches closes the channels of the current watches as a compaction or a restart of etcd would
+func (e *Etcd) CancelWatches() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for watcher := range e.watchers {
+		delete(e.watchers, watcher)
+		close(watcher.cancel)
+	}
+}
+
+// ExpireLease expires the lease as if its keep alives had stopped, the keys of the lease are deleted
+func (e *Etcd) ExpireLease(id clientv3.LeaseID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.revokeLocked(id)
+}
+
+// SetGrantDelay delays the following grants by d as a slow etcd would, e.g. to stop a registration during its grant
+func (e *Etcd) SetGrantDelay(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.grantDelay = d
+}
+
+func (e *Etcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: e.rev}
+}
+
+// opField reads the options of an op which have no accessor, e.g. the lease of a put
+func opField(op clientv3.Op, name string) reflect.Value {
+	return reflect.ValueOf(op).FieldByName(name)
+}
+
+func (e *Etcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	resp, err := e.Do(ctx, clientv3.OpPut(key, val, opts...))
+	return resp.Put(), err
+}
+
+func (e *Etcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := e.Do(ctx, clientv3.OpGet(key, opts...))
+	return resp.Get(), err
+}
+
+func (e *Etcd) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp, err := e.Do(ctx, clientv3.OpDelete(key, opts...))
+	return resp.Del(), err
+}
+
+func (e *Etcd) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &clientv3.CompactResponse{Header: e.header()}, nil
+}
+
+func (e *Etcd) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expireLocked()
+	return e.doLocked(op)
+}
+
+func (e *Etcd) Txn(ctx context.Context) clientv3.Txn {
+	return &etcdTxn{etcd: e, ctx: ctx}
+}
+
+func (e *Etcd) doLocked(op clientv3.Op) (clientv3.OpResponse, error) {
+	switch {
+	case op.IsGet():
+		return e.rangeLocked(op).OpResponse(), nil
+	case op.IsPut():
+		resp, err := e.putLocked(op)
+		if err != nil {
+			return clientv3.OpResponse{}, err
+		}
+		return resp.OpResponse(), nil
+	case op.IsDelete():
+		return e.deleteLocked(op).OpResponse(), nil
+	case op.IsTxn():
+		cmps, thenOps, elseOps := op.Txn()
+		resp, err := e.txnLocked(cmps, thenOps, elseOps)
+		if err != nil {
+			return clientv3.OpResponse{}, err
+		}
+		return resp.OpResponse(), nil
+	}
+	return clientv3.OpResponse{}, errors.New("servertest: unknown etcd op")
+}
+
+// keysLocked returns the keys of [key, end) sorted, end is empty for a single key and "\x00" for every key from key
+func (e *Etcd) keysLocked(key, end []byte) []string {
+	if len(end) == 0 {
+		if _, ok := e.kvs[string(key)]; ok {
+			return []string{string(key)}
+		}
+		return nil
+	}
+	var keys []string
+	for k := range e.kvs {
+		if inRange([]byte(k), key, end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func inRange(k, key, end []byte) bool {
+	if len(end) == 0 {
+		return bytes.Equal(k, key)
+	}
+	if bytes.Compare(k, key) < 0 {
+		return false
+	}
+	return (len(end) == 1 && end[0] == 0) || bytes.Compare(k, end) < 0
+}
+
+func (e *Etcd) rangeLocked(op clientv3.Op) *clientv3.GetResponse {
+	keys := e.keysLocked(op.KeyBytes(), op.RangeBytes())
+	resp := &clientv3.GetResponse{Header: e.header(), Count: int64(len(keys))}
+	if op.IsCountOnly() {
+		return resp
+	}
+	if limit := opField(op, "limit").Int(); limit > 0 && int64(len(keys)) > limit {
+		keys, resp.More = keys[:limit], true
+	}
+	for _, k := range keys {
+		kv := *e.kvs[k]
+		if op.IsKeysOnly() {
+			kv.Value = nil
+		}
+		resp.Kvs = append(resp.Kvs, &kv)
+	}
+	return resp
+}
+
+func (e *Etcd) putLocked(op clientv3.Op) (*clientv3.PutResponse, error) {
+	key := string(op.KeyBytes())
+	leaseID := clientv3.LeaseID(opField(op, "leaseID").Int())
+	var lease *etcdLease
+	if leaseID != clientv3.NoLease {
+		var ok bool
+		if lease, ok = e.leases[leaseID]; !ok {
+			return nil, rpctypes.ErrLeaseNotFound
+		}
+	}
+	e.rev++
+	prev := e.kvs[key]
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: op.ValueBytes(), CreateRevision: e.rev, ModRevision: e.rev, Version: 1, Lease: int64(leaseID)}
+	if prev != nil {
+		kv.CreateRevision, kv.Version = prev.CreateRevision, prev.Version+1
+		if old, ok := e.leases[clientv3.LeaseID(prev.Lease)]; ok {
+			delete(old.keys, key)
+		}
+	}
+	if lease != nil {
+		lease.keys[key] = struct{}{}
+	}
+	e.kvs[key] = kv
+	e.notifyLocked(&clientv3.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: prev})
+	resp := &clientv3.PutResponse{Header: e.header()}
+	if prev != nil && opField(op, "prevKV").Bool() {
+		resp.PrevKv = prev
+	}
+	return resp, nil
+}
+
+func (e *Etcd) deleteLocked(op clientv3.Op) *clientv3.DeleteResponse {
+	keys := e.keysLocked(op.KeyBytes(), op.RangeBytes())
+	if len(keys) > 0 {
+		e.rev++
+	}
+	resp := &clientv3.DeleteResponse{Header: e.header(), Deleted: int64(len(keys))}
+	for _, k := range keys {
+		prev := e.deleteKeyLocked(k)
+		if opField(op, "prevKV").Bool() {
+			resp.PrevKvs = append(resp.PrevKvs, prev)
+		}
+	}
+	return resp
+}
+
+// deleteKeyLocked deletes the key at the current revision
+func (e *Etcd) deleteKeyLocked(key string) *mvccpb.KeyValue {
+	prev := e.kvs[key]
+	delete(e.kvs, key)
+	if lease, ok := e.leases[clientv3.LeaseID(prev.Lease)]; ok {
+		delete(lease.keys, key)
+	}
+	e.notifyLocked(&clientv3.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: prev.Key, ModRevision: e.rev}, PrevKv: prev})
+	return prev
+}
+
+func (e *Etcd) txnLocked(cmps []clientv3.Cmp, thenOps, elseOps []clientv3.Op) (*clientv3.TxnResponse, error) {
+	succeeded := true
+	for _, cmp := range cmps {
+		if !e.compareLocked(cmp) {
+			succeeded = false
+			break
+		}
+	}
+	ops := thenOps
+	if !succeeded {
+		ops = elseOps
+	}
+	resp := &clientv3.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		opResp, err := e.doLocked(op)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case opResp.Get() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: (*pb.RangeResponse)(opResp.Get())}})
+		case opResp.Put() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: (*pb.PutResponse)(opResp.Put())}})
+		case opResp.Del() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: (*pb.DeleteRangeResponse)(opResp.Del())}})
+		case opResp.Txn() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: (*pb.TxnResponse)(opResp.Txn())}})
+		}
+	}
+	resp.Header = e.header()
+	return resp, nil
+}
+
+// compareLocked compares every key of the range, a missing key has zero revisions, version and lease
+func (e *Etcd) compareLocked(cmp clientv3.Cmp) bool {
+	keys := e.keysLocked(cmp.Key, cmp.RangeEnd)
+	if len(keys) == 0 && len(cmp.RangeEnd) == 0 {
+		return compareKv(cmp, &mvccpb.KeyValue{Key: cmp.Key})
+	}
+	for _, k := range keys {
+		if !compareKv(cmp, e.kvs[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareKv(cmp clientv3.Cmp, kv *mvccpb.KeyValue) bool {
+	var result int
+	switch target := cmp.TargetUnion.(type) {
+	case *pb.Compare_Version:
+		result = compareInt(kv.Version, target.Version)
+	case *pb.Compare_CreateRevision:
+		result = compareInt(kv.CreateRevision, target.CreateRevision)
+	case *pb.Compare_ModRevision:
+		result = compareInt(kv.ModRevision, target.ModRevision)
+	case *pb.Compare_Lease:
+		result = compareInt(kv.Lease, target.Lease)
+	case *pb.Compare_Value:
+		if kv.CreateRevision == 0 {
+			//a missing key has no value
+			return false
+		}
+		result = bytes.Compare(kv.Value, target.Value)
+	default:
+		return false
+	}
+	switch cmp.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	case pb.Compare_LESS:
+		return result < 0
+	}
+	return false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type etcdTxn struct {
+	etcd             *Etcd
+	ctx              context.Context
+	cmps             []clientv3.Cmp
+	thenOps, elseOps []clientv3.Op
+}
+
+func (t *etcdTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = append(t.cmps, cs...)
+	return t
+}
+
+func (t *etcdTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.thenOps = append(t.thenOps, ops...)
+	return t
+}
+
+func (t *etcdTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.elseOps = append(t.elseOps, ops...)
+	return t
+}
+
+func (t *etcdTxn) Commit() (*clientv3.TxnResponse, error) {
+	resp, err := t.etcd.Do(t.ctx, clientv3.OpTxn(t.cmps, t.thenOps, t.elseOps))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Txn(), nil
+}
+
+func (e *Etcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	e.mu.Lock()
+	delay := e.grantDelay
+	e.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextID++
+	e.leases[e.nextID] = &etcdLease{ttl: ttl, expireAt: time.Now().Add(time.Duration(ttl) * time.Second), keys: make(map[string]struct{})}
+	return &clientv3.LeaseGrantResponse{ResponseHeader: e.header(), ID: e.nextID, TTL: ttl}, nil
+}
+
+func (e *Etcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expireLocked()
+	if !e.revokeLocked(id) {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	return &clientv3.LeaseRevokeResponse{Header: e.header()}, nil
+}
+
+func (e *Etcd) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expireLocked()
+	lease, ok := e.leases[id]
+	if !ok {
+		return &clientv3.LeaseTimeToLiveResponse{ResponseHeader: e.header(), ID: id, TTL: -1}, nil
+	}
+	resp := &clientv3.LeaseTimeToLiveResponse{
+		ResponseHeader: e.header(),
+		ID:             id,
+		TTL:            int64(time.Until(lease.expireAt) / time.Second),
+		GrantedTTL:     lease.ttl,
+	}
+	for key := range lease.keys {
+		resp.Keys = append(resp.Keys, []byte(key))
+	}
+	return resp, nil
+}
+
+func (e *Etcd) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expireLocked()
+	resp := &clientv3.LeaseLeasesResponse{ResponseHeader: e.header()}
+	for id := range e.leases {
+		resp.Leases = append(resp.Leases, clientv3.LeaseStatus{ID: id})
+	}
+	return resp, nil
+}
+
+// KeepAlive renews the lease every third of its ttl until ctx is done or the lease is gone
+func (e *Etcd) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	first, err := e.KeepAliveOnce(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	ch <- first
+	go func() {
+		defer close(ch)
+		interval := time.Duration(first.TTL) * time.Second / 3
+		if interval <= 0 {
+			interval = 100 * time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			resp, err := e.KeepAliveOnce(ctx, id)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- resp:
+			default:
+				//the responses are dropped when they are not read, like clientv3 does
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (e *Etcd) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expireLocked()
+	lease, ok := e.leases[id]
+	if !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	lease.expireAt = time.Now().Add(time.Duration(lease.ttl) * time.Second)
+	return &clientv3.LeaseKeepAliveResponse{ResponseHeader: e.header(), ID: id, TTL: lease.ttl}, nil
+}
+
+// Close of the lease api, the leases belong to the Etcd and are kept
+func (e *Etcd) Close() error {
+	return nil
+}
+
+// expireLocked revokes the expired leases, they are checked on every call instead of by a timer
+func (e *Etcd) expireLocked() {
+	now := time.Now()
+	for id, lease := range e.leases {
+		if !now.Before(lease.expireAt) {
+			e.revokeLocked(id)
+		}
+	}
+}
+
+func (e *Etcd) revokeLocked(id clientv3.LeaseID) bool {
+	lease, ok := e.leases[id]
+	if !ok {
+		return false
+	}
+	delete(e.leases, id)
+	if len(lease.keys) == 0 {
+		return true
+	}
+	keys := make([]string, 0, len(lease.keys))
+	for key := range lease.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	e.rev++
+	for _, key := range keys {
+		e.deleteKeyLocked(key)
+	}
+	return true
+}
+
+// etcdWatchClient is the watch api of one client
+type etcdWatchClient struct {
+	etcd *Etcd
+	ctx  context.Context
+}
+
+type etcdWatcher struct {
+	key, end     []byte
+	prevKV       bool
+	filterPut    bool
+	filterDelete bool
+
+	mu     sync.Mutex
+	queue  []*clientv3.Event
+	signal chan struct{}
+	cancel chan struct{}
+}
+
+func (w *etcdWatchClient) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	//the watch options are the ones of a get
+	op := clientv3.OpGet(key, opts...)
+	watcher := &etcdWatcher{
+		key:          op.KeyBytes(),
+		end:          op.RangeBytes(),
+		prevKV:       opField(op, "prevKV").Bool(),
+		filterPut:    opField(op, "filterPut").Bool(),
+		filterDelete: opField(op, "filterDelete").Bool(),
+		signal:       make(chan struct{}, 1),
+		cancel:       make(chan struct{}),
+	}
+	e := w.etcd
+	e.mu.Lock()
+	e.watchers[watcher] = struct{}{}
+	e.mu.Unlock()
+
+	ch := make(chan clientv3.WatchResponse)
+	go func() {
+		defer func() {
+			e.mu.Lock()
+			delete(e.watchers, watcher)
+			e.mu.Unlock()
+			close(ch)
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.ctx.Done():
+				return
+			case <-watcher.cancel:
+				return
+			case <-watcher.signal:
+			}
+			watcher.mu.Lock()
+			events := watcher.queue
+			watcher.queue = nil
+			watcher.mu.Unlock()
+			if len(events) == 0 {
+				continue
+			}
+			resp := clientv3.WatchResponse{Header: pb.ResponseHeader{Revision: events[len(events)-1].Kv.ModRevision}, Events: events}
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+				return
+			case <-w.ctx.Done():
+				return
+			case <-watcher.cancel:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Close of the watch api, the watches are cancelled by the context of the client
+func (w *etcdWatchClient) Close() error {
+	return nil
+}
+
+// notifyLocked queues the event for the watchers of its key, they never block the writes
+func (e *Etcd) notifyLocked(event *clientv3.Event) {
+	for watcher := range e.watchers {
+		if !inRange(event.Kv.Key, watcher.key, watcher.end) ||
+			(event.Type == mvccpb.PUT && watcher.filterPut) || (event.Type == mvccpb.DELETE && watcher.filterDelete) {
+			continue
+		}
+		ev := *event
+		if !watcher.prevKV {
+			ev.PrevKv = nil
+		}
+		watcher.mu.Lock()
+		watcher.queue = append(watcher.queue, &ev)
+		watcher.mu.Unlock()
+		select {
+		case watcher.signal <- struct{}{}:
+		default:
+		}
+	}
+}
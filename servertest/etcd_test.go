@@ -0,0 +1,101 @@
+package servertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdTxn(t *testing.T) {
+	ctx := context.Background()
+	client := NewEtcd().Client()
+	put, err := client.Put(ctx, "/job/a", "1")
+	assert.Nil(t, err)
+
+	//the compare holds, the then ops run
+	resp, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision("/job/a"), "=", put.Header.Revision)).
+		Then(clientv3.OpPut("/job/a", "2")).
+		Else(clientv3.OpGet("/job/a")).
+		Commit()
+	assert.Nil(t, err)
+	assert.True(t, resp.Succeeded)
+
+	//the revision changed, the else ops run
+	resp, err = client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision("/job/a"), "=", put.Header.Revision)).
+		Then(clientv3.OpPut("/job/a", "3")).
+		Else(clientv3.OpGet("/job/a")).
+		Commit()
+	assert.Nil(t, err)
+	assert.False(t, resp.Succeeded)
+	assert.Equal(t, "2", string(resp.Responses[0].GetResponseRange().Kvs[0].Value))
+
+	//a missing key has the create revision 0
+	resp, err = client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision("/job/b"), "=", 0), clientv3.Compare(clientv3.Value("/job/a"), "=", "2")).
+		Then(clientv3.OpPut("/job/b", "1")).
+		Commit()
+	assert.Nil(t, err)
+	assert.True(t, resp.Succeeded)
+	get, err := client.Get(ctx, "/job/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), get.Count)
+}
+
+func TestEtcdLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	client := NewEtcd().Client()
+	lease, err := client.Grant(ctx, 1)
+	assert.Nil(t, err)
+	_, err = client.Put(ctx, "/services/api/a", "1", clientv3.WithLease(lease.ID))
+	assert.Nil(t, err)
+	_, err = client.Put(ctx, "/services/api/b", "1")
+	assert.Nil(t, err)
+
+	//the keys of the lease are deleted once the ttl has passed without a keep alive
+	time.Sleep(1100 * time.Millisecond)
+	get, err := client.Get(ctx, "/services/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(get.Kvs))
+	assert.Equal(t, "/services/api/b", string(get.Kvs[0].Key))
+	_, err = client.KeepAliveOnce(ctx, lease.ID)
+	assert.NotNil(t, err)
+}
+
+func TestEtcdPrefixWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewEtcd().Client()
+	watch := client.Watch(ctx, "/config/", clientv3.WithPrefix())
+	_, err := client.Put(ctx, "/other", "1")
+	assert.Nil(t, err)
+	_, err = client.Put(ctx, "/config/a", "1")
+	assert.Nil(t, err)
+	_, err = client.Delete(ctx, "/config/a")
+	assert.Nil(t, err)
+
+	//the key out of the prefix is not watched
+	var events []*clientv3.Event
+	for len(events) < 2 {
+		select {
+		case resp := <-watch:
+			events = append(events, resp.Events...)
+		case <-time.After(time.Second):
+			t.Fatal("missing watch events")
+		}
+	}
+	assert.Equal(t, mvccpb.PUT, events[0].Type)
+	assert.Equal(t, "/config/a", string(events[0].Kv.Key))
+	assert.Equal(t, mvccpb.DELETE, events[1].Type)
+	assert.Equal(t, "/config/a", string(events[1].Kv.Key))
+
+	//the channel is closed with the context
+	cancel()
+	for range watch {
+	}
+}
@@ -0,0 +1,96 @@
+package servertest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/tmnhs/common/dbclient"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Mysql is a gorm db in dry run mode, the statements are recorded instead of executed, the queries return no rows
+// and the transactions, e.g. db.Transaction, fail as they need a connection
+type Mysql struct {
+	DB *gorm.DB
+
+	mu         sync.Mutex
+	statements []string
+}
+
+// FakeMysql replaces the db of dbclient with a Mysql until the end of the test
+func FakeMysql(t testing.TB) *Mysql {
+	t.Helper()
+	m, err := NewMysql()
+	if err != nil {
+		t.Fatalf("servertest: fake mysql: %v", err)
+	}
+	SetMysql(t, m.DB)
+	return m
+}
+
+// SetMysql replaces the db of dbclient with db until the end of the test, e.g. a db of go-sqlmock
+func SetMysql(t testing.TB, db *gorm.DB) {
+	previous := dbclient.Set(db)
+	t.Cleanup(func() {
+		dbclient.Set(previous)
+	})
+}
+
+// NewMysql returns a Mysql, it never connects to a server
+func NewMysql() (*Mysql, error) {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		//sql.Open does not connect
+		DSN:                       "servertest:servertest@tcp(127.0.0.1:3306)/servertest?parseTime=true",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		//a transaction would connect
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := &Mysql{DB: db}
+	callbacks := db.Callback()
+	for _, register := range []func(name string, fn func(*gorm.DB)) error{
+		callbacks.Create().After("gorm:create").Register,
+		callbacks.Query().After("gorm:query").Register,
+		callbacks.Update().After("gorm:update").Register,
+		callbacks.Delete().After("gorm:delete").Register,
+		callbacks.Row().After("gorm:row").Register,
+		callbacks.Raw().After("gorm:raw").Register,
+	} {
+		if err := register("servertest:record", m.record); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Mysql) record(db *gorm.DB) {
+	if db.Statement.SQL.Len() == 0 {
+		return
+	}
+	statement := db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)
+	m.mu.Lock()
+	m.statements = append(m.statements, statement)
+	m.mu.Unlock()
+}
+
+// Statements returns the statements with their values in the order they were built
+func (m *Mysql) Statements() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.statements...)
+}
+
+// Reset forgets the recorded statements
+func (m *Mysql) Reset() {
+	m.mu.Lock()
+	m.statements = nil
+	m.mu.Unlock()
+}
@@ -0,0 +1,56 @@
+package servertest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tmnhs/common/notify"
+)
+
+// Notify records the messages of notify instead of sending the mails and webhooks
+type Notify struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+// FakeNotify initializes notify with a Notify until the end of the test, notify is closed by the cleanup
+func FakeNotify(t testing.TB) *Notify {
+	n := &Notify{}
+	notify.Init(&notify.Mail{}, &notify.WebHook{})
+	previousMail := notify.SetNoticer(1, n)
+	previousWebHook := notify.SetNoticer(2, n)
+	go notify.Serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = notify.Close(ctx)
+		notify.SetNoticer(1, previousMail)
+		notify.SetNoticer(2, previousWebHook)
+	})
+	return n
+}
+
+func (n *Notify) SendMsg(msg *notify.Message) {
+	n.mu.Lock()
+	n.messages = append(n.messages, *msg)
+	n.mu.Unlock()
+}
+
+// Messages waits for the messages already sent by notify.Send and returns every recorded message
+func (n *Notify) Messages() []notify.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_ = notify.Flush(ctx)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]notify.Message(nil), n.messages...)
+}
+
+// Reset forgets the recorded messages
+func (n *Notify) Reset() {
+	n.mu.Lock()
+	n.messages = nil
+	n.mu.Unlock()
+}
@@ -0,0 +1,626 @@
+package servertest
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/tmnhs/common/redisclient"
+)
+
+// Redis is an in-memory redis server for the string, hash and key commands, e.g. GET, SET EX NX, INCR,
+// HSET, HGETALL, EXPIRE and KEYS. The other commands fail with "ERR unknown command". The scripts
+// (EVAL, EVALSHA, SCRIPT LOAD) are not interpreted, the scripts of this module are recognized by their
+// sha1 and run in go, e.g. the token bucket of redisclient.AllowN, the other ones fail.
+type Redis struct {
+	mu     sync.Mutex
+	values map[string]*redisValue
+	//sha1 of the scripts loaded by EVAL or SCRIPT LOAD, EVALSHA fails with NOSCRIPT for the other ones
+	loaded map[string]bool
+}
+
+// redisScript runs a script with the lock of Redis held
+type redisScript func(r *Redis, keys, args []string) interface{}
+
+// redisScripts are the known scripts by sha1
+var redisScripts = map[string]redisScript{
+	redisclient.TokenBucketScriptHash(): (*Redis).tokenBucket,
+}
+
+type redisValue struct {
+	str      string
+	hash     map[string]string
+	expireAt time.Time
+}
+
+var errRedisWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// FakeRedis replaces the client of redisclient with a client of a new Redis until the end of the test
+func FakeRedis(t testing.TB) *Redis {
+	r := NewRedis()
+	client := r.Client()
+	previous := redisclient.Set(client)
+	t.Cleanup(func() {
+		redisclient.Set(previous)
+		client.Close()
+	})
+	return r
+}
+
+// NewRedis returns an empty Redis
+func NewRedis() *Redis {
+	return &Redis{values: make(map[string]*redisValue), loaded: make(map[string]bool)}
+}
+
+// Client returns a client of r, the connections are in memory
+func (r *Redis) Client() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: "servertest:6379",
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, conn := net.Pipe()
+			go r.serve(conn)
+			return client, nil
+		},
+	})
+}
+
+// FlushAll deletes every key
+func (r *Redis) FlushAll() {
+	r.mu.Lock()
+	r.values = make(map[string]*redisValue)
+	r.mu.Unlock()
+}
+
+// Keys returns the keys which have not expired, sorted
+func (r *Redis) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := r.keysLocked("*")
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *Redis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		writeReply(writer, r.exec(args))
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command of the resp protocol, the clients send arrays of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("unexpected %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// redisStatus is a simple string reply, e.g. OK
+type redisStatus string
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case redisStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v.Error())
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+func (r *Redis) exec(args []string) interface{} {
+	if len(args) == 0 {
+		return errors.New("ERR empty command")
+	}
+	name, args := strings.ToUpper(args[0]), args[1:]
+	arity, ok := redisCommands[name]
+	if !ok {
+		return fmt.Errorf("ERR unknown command '%s'", strings.ToLower(name))
+	}
+	if len(args) < arity {
+		return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch name {
+	case "PING":
+		if len(args) > 0 {
+			return args[0]
+		}
+		return redisStatus("PONG")
+	case "ECHO":
+		return args[0]
+	case "SELECT", "CLIENT":
+		return redisStatus("OK")
+	case "FLUSHDB", "FLUSHALL":
+		r.values = make(map[string]*redisValue)
+		return redisStatus("OK")
+	case "DBSIZE":
+		return len(r.keysLocked("*"))
+	case "KEYS":
+		return r.keysLocked(args[0])
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if r.getLocked(key) != nil {
+				n++
+			}
+		}
+		return n
+	case "DEL", "UNLINK":
+		n := 0
+		for _, key := range args {
+			if r.getLocked(key) != nil {
+				delete(r.values, key)
+				n++
+			}
+		}
+		return n
+	case "TYPE":
+		v := r.getLocked(args[0])
+		switch {
+		case v == nil:
+			return redisStatus("none")
+		case v.hash != nil:
+			return redisStatus("hash")
+		}
+		return redisStatus("string")
+	case "EXPIRE", "PEXPIRE":
+		ttl, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		v := r.getLocked(args[0])
+		if v == nil {
+			return 0
+		}
+		unit := time.Second
+		if name == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		v.expireAt = time.Now().Add(time.Duration(ttl) * unit)
+		return 1
+	case "PERSIST":
+		v := r.getLocked(args[0])
+		if v == nil || v.expireAt.IsZero() {
+			return 0
+		}
+		v.expireAt = time.Time{}
+		return 1
+	case "TTL", "PTTL":
+		v := r.getLocked(args[0])
+		if v == nil {
+			return -2
+		}
+		if v.expireAt.IsZero() {
+			return -1
+		}
+		if name == "PTTL" {
+			return int64(time.Until(v.expireAt) / time.Millisecond)
+		}
+		return int64((time.Until(v.expireAt) + time.Second - 1) / time.Second)
+	case "GET":
+		v := r.getLocked(args[0])
+		if v == nil {
+			return nil
+		}
+		if v.hash != nil {
+			return errRedisWrongType
+		}
+		return v.str
+	case "MGET":
+		values := make([]interface{}, 0, len(args))
+		for _, key := range args {
+			if v := r.getLocked(key); v != nil && v.hash == nil {
+				values = append(values, v.str)
+			} else {
+				values = append(values, nil)
+			}
+		}
+		return values
+	case "SET":
+		return r.set(args[0], args[1], args[2:])
+	case "SETEX":
+		return r.set(args[0], args[2], []string{"EX", args[1]})
+	case "SETNX":
+		if r.set(args[0], args[1], []string{"NX"}) == nil {
+			return 0
+		}
+		return 1
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		delta := int64(1)
+		if len(args) > 1 {
+			var err error
+			if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+		}
+		if strings.HasPrefix(name, "DECR") {
+			delta = -delta
+		}
+		v := r.getLocked(args[0])
+		if v == nil {
+			v = &redisValue{str: "0"}
+			r.values[args[0]] = v
+		}
+		if v.hash != nil {
+			return errRedisWrongType
+		}
+		n, err := strconv.ParseInt(v.str, 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		n += delta
+		v.str = strconv.FormatInt(n, 10)
+		return n
+	case "HSET", "HMSET":
+		if len(args)%2 != 1 {
+			return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+		}
+		v, err := r.hashLocked(args[0], true)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := v.hash[args[i]]; !ok {
+				n++
+			}
+			v.hash[args[i]] = args[i+1]
+		}
+		if name == "HMSET" {
+			return redisStatus("OK")
+		}
+		return n
+	case "HGET":
+		v, err := r.hashLocked(args[0], false)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return nil
+		}
+		if value, ok := v.hash[args[1]]; ok {
+			return value
+		}
+		return nil
+	case "HEXISTS":
+		v, err := r.hashLocked(args[0], false)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return 0
+		}
+		if _, ok := v.hash[args[1]]; ok {
+			return 1
+		}
+		return 0
+	case "HDEL":
+		v, err := r.hashLocked(args[0], false)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return 0
+		}
+		n := 0
+		for _, field := range args[1:] {
+			if _, ok := v.hash[field]; ok {
+				delete(v.hash, field)
+				n++
+			}
+		}
+		if len(v.hash) == 0 {
+			delete(r.values, args[0])
+		}
+		return n
+	case "HLEN":
+		v, err := r.hashLocked(args[0], false)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return 0
+		}
+		return len(v.hash)
+	case "HGETALL":
+		v, err := r.hashLocked(args[0], false)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return []string{}
+		}
+		fields := make([]string, 0, len(v.hash))
+		for field := range v.hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		reply := make([]string, 0, 2*len(fields))
+		for _, field := range fields {
+			reply = append(reply, field, v.hash[field])
+		}
+		return reply
+	case "EVAL":
+		sha := fmt.Sprintf("%x", sha1.Sum([]byte(args[0])))
+		if _, ok := redisScripts[sha]; !ok {
+			return errors.New("ERR servertest: unsupported script")
+		}
+		r.loaded[sha] = true
+		return r.eval(sha, args[1:])
+	case "EVALSHA":
+		sha := strings.ToLower(args[0])
+		if !r.loaded[sha] {
+			return errors.New("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return r.eval(sha, args[1:])
+	case "SCRIPT":
+		switch strings.ToUpper(args[0]) {
+		case "LOAD":
+			if len(args) < 2 {
+				return errors.New("ERR wrong number of arguments for 'script|load' command")
+			}
+			sha := fmt.Sprintf("%x", sha1.Sum([]byte(args[1])))
+			if _, ok := redisScripts[sha]; !ok {
+				return errors.New("ERR servertest: unsupported script")
+			}
+			r.loaded[sha] = true
+			return sha
+		case "EXISTS":
+			reply := make([]interface{}, 0, len(args)-1)
+			for _, sha := range args[1:] {
+				if r.loaded[strings.ToLower(sha)] {
+					reply = append(reply, int64(1))
+				} else {
+					reply = append(reply, int64(0))
+				}
+			}
+			return reply
+		case "FLUSH":
+			r.loaded = make(map[string]bool)
+			return redisStatus("OK")
+		}
+		return fmt.Errorf("ERR unknown subcommand '%s'", args[0])
+	case "HINCRBY":
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		v, err := r.hashLocked(args[0], true)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(v.hash[args[1]], 10, 64)
+		if err != nil && v.hash[args[1]] != "" {
+			return errors.New("ERR hash value is not an integer")
+		}
+		n += delta
+		v.hash[args[1]] = strconv.FormatInt(n, 10)
+		return n
+	}
+	return fmt.Errorf("ERR unknown command '%s'", strings.ToLower(name))
+}
+
+// redisCommands are the supported commands and their minimum number of arguments
+var redisCommands = map[string]int{
+	"PING": 0, "ECHO": 1, "SELECT": 1, "CLIENT": 0, "FLUSHDB": 0, "FLUSHALL": 0, "DBSIZE": 0, "KEYS": 1,
+	"EXISTS": 1, "DEL": 1, "UNLINK": 1, "TYPE": 1, "EXPIRE": 2, "PEXPIRE": 2, "PERSIST": 1, "TTL": 1, "PTTL": 1,
+	"GET": 1, "MGET": 1, "SET": 2, "SETEX": 3, "SETNX": 2, "INCR": 1, "DECR": 1, "INCRBY": 2, "DECRBY": 2,
+	"HSET": 3, "HMSET": 3, "HGET": 2, "HEXISTS": 2, "HDEL": 2, "HLEN": 1, "HGETALL": 1, "HINCRBY": 3,
+	"EVAL": 2, "EVALSHA": 2, "SCRIPT": 1,
+}
+
+// eval runs the script of sha with the numkeys, keys and args of EVAL or EVALSHA
+func (r *Redis) eval(sha string, args []string) interface{} {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 {
+		return errors.New("ERR value is not an integer or out of range")
+	}
+	if numKeys > len(args)-1 {
+		return errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	return redisScripts[sha](r, args[1:1+numKeys], args[1+numKeys:])
+}
+
+// tokenBucket is the script of redisclient.AllowN: KEYS[1] is the bucket, ARGV are the rate, the burst and
+// the requested tokens, it returns {allowed, retry after in milliseconds}
+func (r *Redis) tokenBucket(keys, args []string) interface{} {
+	if len(keys) < 1 || len(args) < 3 {
+		return errors.New("ERR servertest: token bucket needs 1 key and 3 args")
+	}
+	rate, err1 := strconv.ParseFloat(args[0], 64)
+	burst, err2 := strconv.ParseFloat(args[1], 64)
+	requested, err3 := strconv.ParseFloat(args[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return errors.New("ERR servertest: invalid token bucket args")
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	v, err := r.hashLocked(keys[0], true)
+	if err != nil {
+		return err
+	}
+	tokens, err1 := strconv.ParseFloat(v.hash["tokens"], 64)
+	ts, err2 := strconv.ParseInt(v.hash["ts"], 10, 64)
+	if err1 != nil || err2 != nil {
+		tokens, ts = burst, now
+	}
+	tokens = math.Min(burst, tokens+math.Max(0, float64(now-ts))*rate/1000)
+
+	allowed, retry := int64(0), int64(0)
+	if tokens >= requested {
+		tokens -= requested
+		allowed = 1
+	} else {
+		retry = int64(math.Ceil((requested - tokens) * 1000 / rate))
+	}
+	//tostring of lua
+	v.hash["tokens"] = strconv.FormatFloat(tokens, 'g', 14, 64)
+	v.hash["ts"] = strconv.FormatInt(now, 10)
+	v.expireAt = time.Now().Add(time.Duration(math.Ceil(burst*1000/rate)+1000) * time.Millisecond)
+	return []interface{}{allowed, retry}
+}
+
+// set is SET key value [EX seconds|PX milliseconds|KEEPTTL] [NX|XX]
+func (r *Redis) set(key, value string, options []string) interface{} {
+	var expireAt time.Time
+	keepTtl, nx, xx := false, false, false
+	for i := 0; i < len(options); i++ {
+		switch option := strings.ToUpper(options[i]); option {
+		case "EX", "PX":
+			if i+1 >= len(options) {
+				return errors.New("ERR syntax error")
+			}
+			ttl, err := strconv.ParseInt(options[i+1], 10, 64)
+			if err != nil || ttl <= 0 {
+				return errors.New("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			expireAt = time.Now().Add(time.Duration(ttl) * unit)
+			i++
+		case "KEEPTTL":
+			keepTtl = true
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errors.New("ERR syntax error")
+		}
+	}
+	old := r.getLocked(key)
+	if (nx && old != nil) || (xx && old == nil) {
+		return nil
+	}
+	if keepTtl && old != nil {
+		expireAt = old.expireAt
+	}
+	r.values[key] = &redisValue{str: value, expireAt: expireAt}
+	return redisStatus("OK")
+}
+
+// getLocked returns the value of key, nil when it does not exist or has expired
+func (r *Redis) getLocked(key string) *redisValue {
+	v, ok := r.values[key]
+	if !ok {
+		return nil
+	}
+	if !v.expireAt.IsZero() && !time.Now().Before(v.expireAt) {
+		delete(r.values, key)
+		return nil
+	}
+	return v
+}
+
+func (r *Redis) hashLocked(key string, create bool) (*redisValue, error) {
+	v := r.getLocked(key)
+	if v == nil {
+		if !create {
+			return nil, nil
+		}
+		v = &redisValue{hash: make(map[string]string)}
+		r.values[key] = v
+	}
+	if v.hash == nil {
+		return nil, errRedisWrongType
+	}
+	return v, nil
+}
+
+func (r *Redis) keysLocked(pattern string) []string {
+	keys := make([]string, 0, len(r.values))
+	for key := range r.values {
+		if r.getLocked(key) == nil {
+			continue
+		}
+		if matchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// matchGlob matches the * and ? of a KEYS pattern, they also match the separators, e.g. ':' or '/'
+func matchGlob(pattern, key string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+	matched, _ := regexp.MatchString("^"+expr+"$", key)
+	return matched
+}
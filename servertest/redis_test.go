@@ -0,0 +1,102 @@
+package servertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common/redisclient"
+)
+
+func TestRedisSet(t *testing.T) {
+	ctx := context.Background()
+	client := NewRedis().Client()
+	defer client.Close()
+
+	//NX only sets a missing key
+	ok, err := client.SetNX(ctx, "lock", "a", 50*time.Millisecond).Result()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = client.SetNX(ctx, "lock", "b", time.Minute).Result()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ttl, err := client.PTTL(ctx, "lock").Result()
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 50*time.Millisecond)
+
+	//the key expires, NX sets it again
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.Get(ctx, "lock").Result()
+	assert.Equal(t, redis.Nil, err)
+	ok, err = client.SetNX(ctx, "lock", "b", 0).Result()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, err = client.TTL(ctx, "lock").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	//EX with XX and KEEPTTL
+	assert.Nil(t, client.Set(ctx, "lock", "c", 10*time.Second).Err())
+	assert.Nil(t, client.SetArgs(ctx, "lock", "d", redis.SetArgs{KeepTTL: true, Mode: "XX"}).Err())
+	ttl, err = client.TTL(ctx, "lock").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, ttl)
+	assert.Equal(t, "d", client.Get(ctx, "lock").Val())
+	assert.Equal(t, redis.Nil, client.SetArgs(ctx, "missing", "e", redis.SetArgs{Mode: "XX"}).Err())
+}
+
+func TestRedisHash(t *testing.T) {
+	ctx := context.Background()
+	client := NewRedis().Client()
+	defer client.Close()
+
+	n, err := client.HSet(ctx, "user:1", "name", "tom", "age", "18").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	all, err := client.HGetAll(ctx, "user:1").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"name": "tom", "age": "18"}, all)
+
+	age, err := client.HIncrBy(ctx, "user:1", "age", 2).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), age)
+	assert.Nil(t, client.HDel(ctx, "user:1", "name").Err())
+	all, err = client.HGetAll(ctx, "user:1").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"age": "20"}, all)
+
+	//a missing hash is empty, a string is of the wrong type
+	all, err = client.HGetAll(ctx, "user:2").Result()
+	assert.Nil(t, err)
+	assert.Empty(t, all)
+	assert.Nil(t, client.Set(ctx, "name", "tom", 0).Err())
+	assert.NotNil(t, client.HGetAll(ctx, "name").Err())
+}
+
+func TestRedisUnknownCommand(t *testing.T) {
+	client := NewRedis().Client()
+	defer client.Close()
+	err := client.LPush(context.Background(), "list", "a").Err()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown command")
+	//the scripts are not interpreted
+	err = client.Eval(context.Background(), "return 1", nil).Err()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unsupported script")
+}
+
+func TestRedisScriptLoad(t *testing.T) {
+	ctx := context.Background()
+	client := NewRedis().Client()
+	defer client.Close()
+	sha := redisclient.TokenBucketScriptHash()
+	//EVALSHA needs the script to be loaded first
+	err := client.EvalSha(ctx, sha, []string{"bucket"}, 1, 1, 1).Err()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "NOSCRIPT")
+	exists, err := client.ScriptExists(ctx, sha).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, exists)
+}
@@ -0,0 +1,197 @@
+// Package servertest runs an ApiServer in process for the handler tests, the MySQL, Redis, etcd and notify
+// singletons can be replaced with the fakes of this package so that the tests run offline:
+//
+//	func TestOrder(t *testing.T) {
+//		servertest.FakeRedis(t)
+//		s := servertest.New(t, &common.Config{}, func(srv *server.ApiServer) {
+//			srv.RegisterRouters(router.Order)
+//		})
+//		resp := s.PostJSON("/order", map[string]interface{}{"id": 1})
+//		assert.Equal(t, common.SUCCESS, resp.Code)
+//	}
+package servertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/health"
+	"github.com/tmnhs/common/server"
+)
+
+// Server is an ApiServer served by an httptest.Server, it is closed by the cleanup of the test
+type Server struct {
+	*server.ApiServer
+	// URL of the httptest.Server, e.g. http://127.0.0.1:40283
+	URL string
+	// Client sends the requests of the helpers, Header is added to each of them
+	Client *http.Client
+	Header http.Header
+	// Health is the health registry of the server, the checks of the options are registered there
+	Health *health.Registry
+
+	t          testing.TB
+	httpServer *httptest.Server
+}
+
+// Response is the http response with the decoded common.Response
+type Response struct {
+	common.Response
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// DecodeData decodes the data of the response into v, e.g. a *PageResult
+func (r *Response) DecodeData(v interface{}) error {
+	var raw struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(r.Body, &raw); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw.Data, v)
+}
+
+// New builds the server of c in the testing environment with the options, setup registers the services,
+// middlewares and routers, they are added the same way as ListenAndServe does. The config is not loaded
+// from the files nor reloaded, the zero config is used when c is nil. The options must not connect to real
+// clients, e.g. use FakeRedis instead of server.WithRedis.
+func New(t testing.TB, c *common.Config, setup func(srv *server.ApiServer), opts ...server.Option) *Server {
+	t.Helper()
+	config := common.Config{}
+	if c != nil {
+		config = *c
+	}
+	if config.Log.Director == "" {
+		//the logger is only initialized once per process, see Builder.Config
+		config.Log = common.Log{
+			Level:         "error",
+			Format:        "console",
+			Director:      filepath.Join(os.TempDir(), "servertest-log"),
+			EncodeLevel:   "LowercaseLevelEncoder",
+			StacktraceKey: "stacktrace",
+		}
+	}
+	registry := health.NewRegistry()
+	srv, err := server.NewBuilder().
+		Env(common.Environment("testing")).
+		Config(&config).
+		HealthRegistry(registry).
+		ShareClients().
+		Options(opts...).
+		Build()
+	if err != nil {
+		t.Fatalf("servertest: build server: %v", err)
+	}
+	//Build sets the gin mode of the environment
+	gin.SetMode(gin.TestMode)
+	if setup != nil {
+		setup(srv)
+	}
+	httpServer := httptest.NewServer(srv.BuildEngine())
+	registry.SetReady(true)
+	s := &Server{
+		ApiServer:  srv,
+		URL:        httpServer.URL,
+		Client:     httpServer.Client(),
+		Header:     make(http.Header),
+		Health:     registry,
+		t:          t,
+		httpServer: httpServer,
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close closes the httptest.Server, the shutdown hooks of the ApiServer are not run
+func (s *Server) Close() {
+	s.Health.SetReady(false)
+	s.httpServer.Close()
+}
+
+// Do sends the request to the server, the path is relative to URL, body is encoded as JSON unless it is
+// an io.Reader, a nil body sends no body
+func (s *Server) Do(method, path string, body interface{}, header http.Header) *Response {
+	s.t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("servertest: encode %s %s body: %v", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		s.t.Fatalf("servertest: new request %s %s: %v", method, path, err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, h := range []http.Header{s.Header, header} {
+		for key, values := range h {
+			req.Header[key] = values
+		}
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		s.t.Fatalf("servertest: %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("servertest: read %s %s response: %v", method, path, err)
+	}
+	r := &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}
+	if len(data) > 0 && isJSON(resp.Header) {
+		if err := json.Unmarshal(data, &r.Response); err != nil {
+			s.t.Fatalf("servertest: decode %s %s response %q: %v", method, path, data, err)
+		}
+	}
+	return r
+}
+
+// Get sends a GET request
+func (s *Server) Get(path string) *Response {
+	s.t.Helper()
+	return s.Do(http.MethodGet, path, nil, nil)
+}
+
+// PostJSON sends a POST request with the JSON of body
+func (s *Server) PostJSON(path string, body interface{}) *Response {
+	s.t.Helper()
+	return s.Do(http.MethodPost, path, body, nil)
+}
+
+// PutJSON sends a PUT request with the JSON of body
+func (s *Server) PutJSON(path string, body interface{}) *Response {
+	s.t.Helper()
+	return s.Do(http.MethodPut, path, body, nil)
+}
+
+// Delete sends a DELETE request
+func (s *Server) Delete(path string) *Response {
+	s.t.Helper()
+	return s.Do(http.MethodDelete, path, nil, nil)
+}
+
+func isJSON(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "application/json")
+}
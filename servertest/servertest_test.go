@@ -0,0 +1,111 @@
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmnhs/common"
+	"github.com/tmnhs/common/dbclient"
+	"github.com/tmnhs/common/etcdclient"
+	"github.com/tmnhs/common/notify"
+	"github.com/tmnhs/common/redisclient"
+	"github.com/tmnhs/common/server"
+)
+
+type user struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestServer(t *testing.T) {
+	FakeRedis(t)
+	mysql := FakeMysql(t)
+	c := &common.Config{}
+	c.System.RateLimit.Enable = true
+	c.System.RateLimit.Rate = 0.001
+	c.System.RateLimit.KeyBy = "ip"
+	c.System.RateLimit.Burst = 2
+	s := New(t, c, func(srv *server.ApiServer) {
+		srv.RegisterRouters(func(engine *gin.Engine) {
+			engine.POST("/user", func(c *gin.Context) {
+				var u user
+				if err := c.ShouldBindJSON(&u); err != nil {
+					common.FailWithMessage(common.ErrorRequestParameter, err.Error(), c)
+					return
+				}
+				dbclient.GetMysqlDB().Create(&u)
+				_ = redisclient.SetToRedis(c.Request.Context(), "user", u, time.Minute)
+				common.OkWithData(u, c)
+			})
+			engine.GET("/user", func(c *gin.Context) {
+				var u user
+				if err := redisclient.GetFromRedis(c.Request.Context(), "user", &u); err != nil {
+					common.FailWithMessage(common.ERROR, err.Error(), c)
+					return
+				}
+				common.OkWithData(u, c)
+			})
+		})
+	})
+
+	resp := s.PostJSON("/user", user{ID: 1, Name: "tom"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, common.SUCCESS, resp.Code)
+	assert.Equal(t, []string{"INSERT INTO `users` (`name`,`id`) VALUES ('tom',1)"}, mysql.Statements())
+
+	resp = s.Get("/user")
+	var u user
+	assert.Nil(t, resp.DecodeData(&u))
+	assert.Equal(t, user{ID: 1, Name: "tom"}, u)
+	assert.NotEmpty(t, resp.Header.Get("X-Request-Id"))
+
+	//the built-in middlewares follow the config of the server
+	resp = s.Get("/user")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, common.ErrorTooManyRequests, resp.Code)
+}
+
+func TestFakes(t *testing.T) {
+	e := FakeEtcd(t)
+	_, err := etcdclient.Put("/config/a", "1")
+	assert.Nil(t, err)
+	watch := etcdclient.Watch("/config/", clientv3.WithPrefix())
+	resp, err := etcdclient.Get("/config/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Kvs))
+	_, err = etcdclient.PutWithModRev("/config/a", "2", resp.Kvs[0].ModRevision)
+	assert.Nil(t, err)
+	_, err = etcdclient.PutWithModRev("/config/a", "3", resp.Kvs[0].ModRevision)
+	assert.Equal(t, etcdclient.ErrValueMayChanged, err)
+	event := <-watch
+	assert.Equal(t, "2", string(event.Events[0].Kv.Value))
+
+	lease, err := etcdclient.Grant(10)
+	assert.Nil(t, err)
+	locked, err := etcdclient.GetLock("job", lease.ID)
+	assert.Nil(t, err)
+	assert.True(t, locked)
+	locked, _ = etcdclient.GetLock("job", lease.ID)
+	assert.False(t, locked)
+	e.ExpireLease(lease.ID)
+	locked, _ = etcdclient.GetLock("job", clientv3.NoLease)
+	assert.True(t, locked)
+
+	n := FakeNotify(t)
+	notify.Send(&notify.Message{Type: 2, Subject: "deploy", Body: "done"})
+	messages := n.Messages()
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "deploy", messages[0].Subject)
+
+	r := FakeRedis(t)
+	assert.Nil(t, redisclient.SetToRedis(context.Background(), "a", 1, time.Minute))
+	assert.Equal(t, []string{"a"}, r.Keys())
+	assert.Nil(t, redisclient.DelFromRedis(context.Background(), "a"))
+	_, err = redisclient.GetStringFromRedis(context.Background(), "a")
+	assert.Equal(t, redisclient.ErrRedisNotFound, err)
+}
@@ -0,0 +1,144 @@
+package tracing
+
+// request id and W3C trace context(https://www.w3.org/TR/trace-context/) propagation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tmnhs/common/utils"
+)
+
+const (
+	HeaderRequestID   = "X-Request-Id"
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+
+	//ContextKey is the key of the *Trace in the gin context, FromContext also looks it up
+	ContextKey = "common-trace"
+
+	traceparentVersion = "00"
+	//sampled flag
+	defaultTraceFlags = "01"
+	maxRequestIDLen   = 128
+)
+
+type traceKey struct{}
+
+// Trace is the trace context of one request
+type Trace struct {
+	RequestID string
+	TraceID   string //32 hex characters, shared by every span of the trace
+	SpanID    string //16 hex characters, the span of this service
+	ParentID  string //span of the caller, empty when the trace starts here
+	Flags     string
+	State     string //tracestate, forwarded as is
+}
+
+// FromHeader continues the trace of an incoming request, a missing or invalid
+// request id or traceparent is generated, the trace always gets a new span
+func FromHeader(h http.Header) *Trace {
+	t := &Trace{
+		RequestID: h.Get(HeaderRequestID),
+		SpanID:    randomHex(8),
+		Flags:     defaultTraceFlags,
+	}
+	if t.RequestID == "" || len(t.RequestID) > maxRequestIDLen {
+		t.RequestID = NewRequestID()
+	}
+	if traceID, parentID, flags, ok := ParseTraceparent(h.Get(HeaderTraceparent)); ok {
+		t.TraceID, t.ParentID, t.Flags = traceID, parentID, flags
+		t.State = h.Get(HeaderTracestate)
+	} else {
+		t.TraceID = randomHex(16)
+	}
+	return t
+}
+
+// Traceparent returns the traceparent header of the span of this service
+func (t *Trace) Traceparent() string {
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, t.TraceID, t.SpanID, t.Flags)
+}
+
+// Inject sets the request id and trace context headers of an outgoing request or of a response
+func (t *Trace) Inject(h http.Header) {
+	h.Set(HeaderRequestID, t.RequestID)
+	h.Set(HeaderTraceparent, t.Traceparent())
+	if t.State != "" {
+		h.Set(HeaderTracestate, t.State)
+	}
+}
+
+// NewContext returns a copy of ctx carrying the trace
+func NewContext(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// FromContext returns the trace of a request context or of a gin context, nil if there is none
+func FromContext(ctx context.Context) *Trace {
+	if ctx == nil {
+		return nil
+	}
+	if t, ok := ctx.Value(traceKey{}).(*Trace); ok {
+		return t
+	}
+	if t, ok := ctx.Value(ContextKey).(*Trace); ok {
+		return t
+	}
+	return nil
+}
+
+// Inject sets the headers of the trace of ctx, it does nothing when ctx has no trace
+func Inject(ctx context.Context, h http.Header) {
+	if t := FromContext(ctx); t != nil {
+		t.Inject(h)
+	}
+}
+
+// ParseTraceparent parses a version 00 traceparent header
+func ParseTraceparent(s string) (traceID, parentID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != traceparentVersion {
+		return "", "", "", false
+	}
+	traceID, parentID, flags = parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(parentID, 16) || !isHex(flags, 2) {
+		return "", "", "", false
+	}
+	//all zero ids are invalid
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", "", false
+	}
+	return traceID, parentID, flags, true
+}
+
+// NewRequestID returns a new uuid
+func NewRequestID() string {
+	id, err := utils.UUID()
+	if err != nil {
+		return randomHex(16)
+	}
+	return id
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderRequestID, "req-1")
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set(HeaderTracestate, "vendor=1")
+
+	trace := FromHeader(h)
+	assert.Equal(t, "req-1", trace.RequestID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", trace.ParentID)
+	assert.Len(t, trace.SpanID, 16)
+	assert.NotEqual(t, trace.ParentID, trace.SpanID)
+
+	out := http.Header{}
+	Inject(NewContext(context.Background(), trace), out)
+	assert.Equal(t, "req-1", out.Get(HeaderRequestID))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+trace.SpanID+"-01", out.Get(HeaderTraceparent))
+	assert.Equal(t, "vendor=1", out.Get(HeaderTracestate))
+
+	//invalid traceparent starts a new trace
+	h.Set(HeaderTraceparent, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	h.Del(HeaderRequestID)
+	trace = FromHeader(h)
+	assert.NotEmpty(t, trace.RequestID)
+	assert.Len(t, trace.TraceID, 32)
+	assert.Empty(t, trace.ParentID)
+	assert.Empty(t, trace.State)
+}